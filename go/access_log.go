@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// accessLogIndex is the composite key object type of the access log entries. Each call to accessFile
+// writes a new entry keyed by transfer~txid, so the log is append-only and never rewritten
+const accessLogIndex = "transfer~txid"
+
+type fileAccessLogEntry struct {
+	ObjectType  string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name        string    `json:"name"`    // name of the transfer that was accessed
+	Accessor    string    `json:"accessor"`
+	AccessorMSP string    `json:"accessorMSP"`
+	TxID        string    `json:"txId"`
+	Timestamp   time.Time `json:"timestamp"`                            // transaction timestamp, as set by the client in the proposal
+	Files       []string  `json:"files,omitempty" metadata:",optional"` // files of a bundle that were opened
+}
+
+type fileAccessLog struct {
+	Name        string               `json:"name"`
+	AccessCount int                  `json:"accessCount"`
+	Accesses    []fileAccessLogEntry `json:"accesses"`
+}
+
+// ===========================================================================================
+// getTxTime returns the transaction timestamp from the stub as a time.Time.
+// The timestamp is the same on every endorsing peer, so it is safe to write to state
+// ===========================================================================================
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to get transaction timestamp: %s", err.Error())
+	}
+	txTime, err := ptypes.Timestamp(txTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to convert transaction timestamp: %s", err.Error())
+	}
+	return txTime.UTC(), nil
+}
+
+// ===========================================================================================
+// recordFileAccess appends an entry for the current transaction to the access log of a transfer,
+// along with the files that were opened when the transfer is a bundle
+// ===========================================================================================
+func recordFileAccess(stub shim.ChaincodeStubInterface, collections transferCollections, name string, accessor clientIdentity, files []string) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	entry := &fileAccessLogEntry{
+		ObjectType:  "fileAccess",
+		Name:        name,
+		Accessor:    accessor.ID,
+		AccessorMSP: accessor.MSPID,
+		TxID:        stub.GetTxID(),
+		Timestamp:   txTime,
+		Files:       files,
+	}
+	entryJSONasBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	accessLogKey, err := stub.CreateCompositeKey(accessLogIndex, []string{name, entry.TxID})
+	if err != nil {
+		return err
+	}
+	return collections.putTransfers(stub, accessLogKey, entryJSONasBytes)
+}
+
+// ===========================================================================================
+// GetAccessLog returns every access of a transfer in chronological order, along with the access count.
+// The entries are read with a partial composite key query, so this works on LevelDB as well as CouchDB
+// ===========================================================================================
+func (c *FileTransferContract) GetAccessLog(ctx TransactionContextInterface, name string) (*fileAccessLog, error) {
+	collections, err := getTransferCollections(ctx.GetStub(), ctx.GetCaller(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collections.Transfers, accessLogIndex, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	accessLog := &fileAccessLog{Name: name, Accesses: []fileAccessLogEntry{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry fileAccessLogEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON of: %s", string(queryResponse.Value))
+		}
+		accessLog.Accesses = append(accessLog.Accesses, entry)
+	}
+
+	// entries come back in key order, i.e. by tx ID, so sort them by transaction time
+	sort.SliceStable(accessLog.Accesses, func(i, j int) bool {
+		return accessLog.Accesses[i].Timestamp.Before(accessLog.Accesses[j].Timestamp)
+	})
+	accessLog.AccessCount = len(accessLog.Accesses)
+
+	fmt.Printf("- getAccessLog found %d accesses of %s\n", accessLog.AccessCount, name)
+	return accessLog, nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// authorizationRecordIndex is the composite key object type of the authorization registry, keyed by id
+const authorizationRecordIndex = "authorizationRecord~id"
+
+// Statuses of an authorization
+const (
+	authorizationActive  = "Active"
+	authorizationRevoked = "Revoked"
+)
+
+// authorization is a legal authorization that transfers are made under, referenced by the
+// authorization field of a transfer. The registry is kept in the world state, like the public
+// key registry, so that the peers of every organization can check a transfer against it
+type authorization struct {
+	ObjectType     string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID             string    `json:"id"`
+	Scope          string    `json:"scope"`                                       // what the authorization covers, e.g. a court order reference
+	Issuer         string    `json:"issuer"`                                      // client ID of the issuer, taken from the submitter's certificate
+	IssuerMSP      string    `json:"issuerMSP"`                                   // MSP ID of the issuing organization
+	OriginatorMSPs []string  `json:"originatorMSPs"`                              // organizations that may send files under the authorization
+	RecipientMSPs  []string  `json:"recipientMSPs"`                               // organizations that may receive files under the authorization
+	ValidFrom      time.Time `json:"validFrom"`                                   // zero if the authorization is valid from its creation
+	ValidUntil     time.Time `json:"validUntil"`                                  // zero if the authorization does not expire
+	MaxTransfers   int       `json:"maxTransfers,omitempty" metadata:",optional"` // 0 for no limit
+	TransferCount  int       `json:"transferCount"`                               // number of transfers made under the authorization, only counted when it has a limit
+	Status         string    `json:"status"`                                      // Active or Revoked
+	CreatedAt      time.Time `json:"createdAt"`
+
+	RevocationReason string    `json:"revocationReason,omitempty" metadata:",optional"`
+	RevokedBy        string    `json:"revokedBy,omitempty" metadata:",optional"`
+	RevokedByMSP     string    `json:"revokedByMSP,omitempty" metadata:",optional"`
+	RevokedAt        time.Time `json:"revokedAt"` // zero unless revoked
+}
+
+// getAuthorizationKey returns the key of an authorization in the registry
+func getAuthorizationKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return stub.CreateCompositeKey(authorizationRecordIndex, []string{id})
+}
+
+// ===========================================================================================
+// getAuthorization reads an authorization from the registry
+// ===========================================================================================
+func getAuthorization(stub shim.ChaincodeStubInterface, id string) (*authorization, error) {
+	record, err := findAuthorization(stub, id)
+	if err != nil {
+		return nil, err
+	} else if record == nil {
+		return nil, fmt.Errorf("Authorization does not exist: %s", id)
+	}
+	return record, nil
+}
+
+// ===========================================================================================
+// findAuthorization reads an authorization from the registry, or returns nil if it is not in it.
+// A transfer made before the registry may have been made under an authorization that is not in it
+// ===========================================================================================
+func findAuthorization(stub shim.ChaincodeStubInterface, id string) (*authorization, error) {
+	authorizationKey, err := getAuthorizationKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizationAsBytes, err := stub.GetState(authorizationKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get authorization: %s", err.Error())
+	} else if authorizationAsBytes == nil {
+		return nil, nil
+	}
+
+	var record authorization
+	err = json.Unmarshal(authorizationAsBytes, &record)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", string(authorizationAsBytes))
+	}
+	return &record, nil
+}
+
+// putAuthorization saves an authorization to the registry
+func putAuthorization(stub shim.ChaincodeStubInterface, record *authorization) error {
+	authorizationKey, err := getAuthorizationKey(stub, record.ID)
+	if err != nil {
+		return err
+	}
+	authorizationJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(authorizationKey, authorizationJSONasBytes)
+}
+
+// ===========================================================================================
+// checkAuthorization reads the authorization a transfer is made under and checks that it is
+// active, within its validity period, and that it covers the organizations of the originator
+// and of every recipient. The transfer limit is only checked for a transfer that is new to the
+// authorization, not for one that is already counted against it
+// ===========================================================================================
+func checkAuthorization(stub shim.ChaincodeStubInterface, id string, originatorMSP string, recipients []transferRecipient, newTransfer bool) (*authorization, error) {
+	record, err := getAuthorization(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status == authorizationRevoked {
+		return nil, fmt.Errorf("Authorization %s has been revoked", id)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !record.ValidFrom.IsZero() && txTime.Before(record.ValidFrom) {
+		return nil, fmt.Errorf("Authorization %s is not valid until %s", id, record.ValidFrom.Format(time.RFC3339))
+	}
+	if !record.ValidUntil.IsZero() && !txTime.Before(record.ValidUntil) {
+		return nil, fmt.Errorf("Authorization %s expired at %s", id, record.ValidUntil.Format(time.RFC3339))
+	}
+	if newTransfer && record.MaxTransfers != 0 && record.TransferCount >= record.MaxTransfers {
+		return nil, fmt.Errorf("Authorization %s has reached its limit of %d transfers", id, record.MaxTransfers)
+	}
+
+	if !containsString(record.OriginatorMSPs, originatorMSP) {
+		return nil, fmt.Errorf("Authorization %s does not cover transfers from %s", id, originatorMSP)
+	}
+	for _, recipient := range recipients {
+		if !containsString(record.RecipientMSPs, recipient.MSPID) {
+			return nil, fmt.Errorf("Authorization %s does not cover transfers to %s", id, recipient.MSPID)
+		}
+	}
+	return record, nil
+}
+
+// countAuthorizedTransfer records a new transfer made under an authorization. An authorization
+// without a limit is left as it is, so that concurrent transfers under it do not conflict
+func countAuthorizedTransfer(stub shim.ChaincodeStubInterface, record *authorization) error {
+	if record.MaxTransfers == 0 {
+		return nil
+	}
+	record.TransferCount++
+	return putAuthorization(stub, record)
+}
+
+// uncountAuthorizedTransfer releases a transfer that was moved to another authorization, so that
+// it no longer counts towards the limit of the authorization it was made under
+func uncountAuthorizedTransfer(stub shim.ChaincodeStubInterface, id string) error {
+	record, err := findAuthorization(stub, id)
+	if err != nil || record == nil || record.TransferCount == 0 {
+		return err
+	}
+	record.TransferCount--
+	return putAuthorization(stub, record)
+}
+
+// ===========================================================================================
+// checkAuthorizationNotRevoked returns an error if the authorization a transfer was made under has
+// been revoked, so that its files are cut off even before revokeAuthorizationCascade reaches it
+// ===========================================================================================
+func checkAuthorizationNotRevoked(stub shim.ChaincodeStubInterface, id string) error {
+	record, err := findAuthorization(stub, id)
+	if err != nil {
+		return err
+	} else if record != nil && record.Status == authorizationRevoked {
+		return fmt.Errorf("Authorization %s has been revoked", id)
+	}
+	return nil
+}
+
+// containsString returns true if a list of strings contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMSPList checks a list of MSP IDs of the authorization input
+func checkMSPList(field string, mspIDs []string) error {
+	if len(mspIDs) == 0 {
+		return fmt.Errorf("%s field must contain at least one MSP ID", field)
+	}
+	for i, mspID := range mspIDs {
+		if len(mspID) == 0 {
+			return fmt.Errorf("%s field must not contain empty MSP IDs, got one at %d", field, i)
+		}
+	}
+	return nil
+}
+
+// ===========================================================================================
+// CreateAuthorization - register a legal authorization that transfers can then be made under.
+// The authorization is passed in the transient map under the authorization key, and is issued
+// by the organization of the submitter
+// ===========================================================================================
+func (c *FileTransferContract) CreateAuthorization(ctx TransactionContextInterface) (*authorization, error) {
+	fmt.Println("- start create authorization")
+
+	type authorizationTransientInput struct {
+		ID             string    `json:"id"`
+		Scope          string    `json:"scope"`
+		OriginatorMSPs []string  `json:"originatorMSPs"`
+		RecipientMSPs  []string  `json:"recipientMSPs"`
+		ValidFrom      time.Time `json:"validFrom"`    // optional, RFC 3339
+		ValidUntil     time.Time `json:"validUntil"`   // optional, RFC 3339
+		MaxTransfers   int       `json:"maxTransfers"` // optional, 0 for no limit
+	}
+
+	stub := ctx.GetStub()
+
+	var authorizationInput authorizationTransientInput
+	err := getTransientInput(ctx, "authorization", &authorizationInput)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(authorizationInput.ID) == 0 {
+		return nil, fmt.Errorf("id field must be a non-empty string")
+	}
+	if len(authorizationInput.Scope) == 0 {
+		return nil, fmt.Errorf("scope field must be a non-empty string")
+	}
+	err = checkMSPList("originatorMSPs", authorizationInput.OriginatorMSPs)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMSPList("recipientMSPs", authorizationInput.RecipientMSPs)
+	if err != nil {
+		return nil, err
+	}
+	if authorizationInput.MaxTransfers < 0 {
+		return nil, fmt.Errorf("maxTransfers field must not be negative")
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !authorizationInput.ValidFrom.IsZero() && !authorizationInput.ValidUntil.IsZero() && !authorizationInput.ValidUntil.After(authorizationInput.ValidFrom) {
+		return nil, fmt.Errorf("validUntil field must be later than validFrom")
+	}
+	if !authorizationInput.ValidUntil.IsZero() && !authorizationInput.ValidUntil.After(txTime) {
+		return nil, fmt.Errorf("validUntil field must be in the future")
+	}
+
+	// ==== Check if the authorization already exists ====
+	authorizationKey, err := getAuthorizationKey(stub, authorizationInput.ID)
+	if err != nil {
+		return nil, err
+	}
+	authorizationAsBytes, err := stub.GetState(authorizationKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get authorization: %s", err.Error())
+	} else if authorizationAsBytes != nil {
+		return nil, fmt.Errorf("This authorization already exists: %s", authorizationInput.ID)
+	}
+
+	issuer := ctx.GetCaller()
+	record := &authorization{
+		ObjectType:     "authorization",
+		ID:             authorizationInput.ID,
+		Scope:          authorizationInput.Scope,
+		Issuer:         issuer.ID,
+		IssuerMSP:      issuer.MSPID,
+		OriginatorMSPs: authorizationInput.OriginatorMSPs,
+		RecipientMSPs:  authorizationInput.RecipientMSPs,
+		ValidFrom:      authorizationInput.ValidFrom,
+		ValidUntil:     authorizationInput.ValidUntil,
+		MaxTransfers:   authorizationInput.MaxTransfers,
+		Status:         authorizationActive,
+		CreatedAt:      txTime,
+	}
+	err = putAuthorization(stub, record)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end create authorization (success)")
+	return record, nil
+}
+
+// ===========================================================================================
+// RevokeAuthorization - withdraw an authorization so that no new transfer can be made under it.
+// Transfers already made under it are left as they are. Only a client of the issuing organization
+// may revoke it. The id and reason are passed in the transient map under the authorization_revoke key
+// ===========================================================================================
+func (c *FileTransferContract) RevokeAuthorization(ctx TransactionContextInterface) (*authorization, error) {
+	fmt.Println("- start revoke authorization")
+
+	type authorizationRevokeTransientInput struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason"`
+	}
+
+	stub := ctx.GetStub()
+
+	var revokeInput authorizationRevokeTransientInput
+	err := getTransientInput(ctx, "authorization_revoke", &revokeInput)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revokeInput.ID) == 0 {
+		return nil, fmt.Errorf("id field must be a non-empty string")
+	}
+	if len(revokeInput.Reason) == 0 {
+		return nil, fmt.Errorf("reason field must be a non-empty string")
+	}
+
+	record, err := getAuthorization(stub, revokeInput.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	caller := ctx.GetCaller()
+	if caller.MSPID != record.IssuerMSP {
+		return nil, fmt.Errorf("Only the issuing organization may revoke the authorization: %s", record.ID)
+	}
+	if record.Status == authorizationRevoked {
+		return nil, fmt.Errorf("Authorization %s has already been revoked", record.ID)
+	}
+
+	err = markAuthorizationRevoked(stub, record, revokeInput.Reason, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end revoke authorization (success)")
+	return record, nil
+}
+
+// markAuthorizationRevoked marks an authorization as revoked by the actor and saves it
+func markAuthorizationRevoked(stub shim.ChaincodeStubInterface, record *authorization, reason string, actor clientIdentity) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	record.Status = authorizationRevoked
+	record.RevocationReason = reason
+	record.RevokedBy = actor.ID
+	record.RevokedByMSP = actor.MSPID
+	record.RevokedAt = txTime
+	return putAuthorization(stub, record)
+}
+
+// ===========================================================================================
+// GetAuthorization - read an authorization from the registry
+// ===========================================================================================
+func (c *FileTransferContract) GetAuthorization(ctx TransactionContextInterface, id string) (*authorization, error) {
+	return getAuthorization(ctx.GetStub(), id)
+}
+
+// authorizationTransfers are the transfers made under an authorization, with summary counts
+type authorizationTransfers struct {
+	Authorization string         `json:"authorization"`
+	Total         int            `json:"total"`
+	Accessed      int            `json:"accessed"` // transfers accessed by at least one recipient
+	Revoked       int            `json:"revoked"`
+	Transfers     []fileTransfer `json:"transfers"`
+}
+
+// ===========================================================================================
+// GetTransfersByAuthorization returns every transfer made under an authorization, so auditors can
+// see everything done under it. The transfers are read through the authorization~name composite
+// key index of each collection the caller's organization is a member of, so this works on any
+// state database, and includes transfers made under an authorization that was never registered
+// ===========================================================================================
+func (c *FileTransferContract) GetTransfersByAuthorization(ctx TransactionContextInterface, id string) (*authorizationTransfers, error) {
+	stub := ctx.GetStub()
+
+	records, _, err := getFileTransfersByAuthorization(stub, ctx.GetCaller().MSPID, id, 0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &authorizationTransfers{Authorization: id, Transfers: []fileTransfer{}}
+	for _, record := range records {
+		transfer := *record.Record
+		transfer.normalize()
+		if transfer.HasBeenAccessed {
+			result.Accessed++
+		}
+		if transfer.Status == statusRevoked {
+			result.Revoked++
+		}
+		result.Transfers = append(result.Transfers, transfer)
+	}
+	result.Total = len(result.Transfers)
+
+	fmt.Printf("- getTransfersByAuthorization found %d transfers under %s\n", result.Total, id)
+	return result, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// maxBundleFiles caps the number of files in a bundle, as the whole manifest is rewritten on each access
+const maxBundleFiles = 100
+
+// bundleItem is one file of a bundle, with its own access tracking. The address and key of the
+// file are kept in the private details, see bundleItemPrivateDetails
+type bundleItem struct {
+	Name            string             `json:"name"` // name of the file, unique within the bundle
+	Description     string             `json:"description,omitempty" metadata:",optional"`
+	CiphertextHash  string             `json:"ciphertextHash,omitempty" metadata:",optional"` // hex SHA-256 of the encrypted file
+	CiphertextSize  int64              `json:"ciphertextSize,omitempty" metadata:",optional"` // size in bytes of the encrypted file
+	HasBeenAccessed bool               `json:"hasBeenAccessed"`                               // true once any of the recipients has opened the file
+	AccessedBy      []bundleItemAccess `json:"accessedBy,omitempty" metadata:",optional"`     // recipients who have opened the file
+}
+
+// bundleItemAccess tracks the accesses of one recipient to one file of a bundle
+type bundleItemAccess struct {
+	ID              string    `json:"id"`    // client ID of the recipient
+	MSPID           string    `json:"mspId"` // MSP ID of the recipient
+	AccessCount     int       `json:"accessCount"`
+	FirstAccessedAt time.Time `json:"firstAccessedAt"`
+	LastAccessedAt  time.Time `json:"lastAccessedAt"`
+}
+
+// bundleItemPrivateDetails is the address and key of one file of a bundle
+type bundleItemPrivateDetails struct {
+	Name          string                `json:"name"`
+	Address       string                `json:"address"`  // address of the file, as submitted or rendered from the location
+	Location      *storageLocator       `json:"location"` // typed location of the file
+	EncryptionKey string                `json:"encryptionKey,omitempty" metadata:",optional"`
+	WrappedKeys   []recipientWrappedKey `json:"wrappedKeys,omitempty" metadata:",optional"`
+	PlaintextHash string                `json:"plaintextHash,omitempty" metadata:",optional"`
+	PlaintextSize int64                 `json:"plaintextSize,omitempty" metadata:",optional"`
+}
+
+// bundleFileTransientInput is one file of a new bundle, as passed in the transient map
+type bundleFileTransientInput struct {
+	Name          string                    `json:"name"`
+	Description   string                    `json:"description"` // optional
+	Address       string                    `json:"address"`     // IPFS CID of the file, shorthand for an ipfs location
+	Location      *storageLocator           `json:"location"`    // alternative to address
+	EncryptionKey string                    `json:"encryptionKey"`
+	WrappedKeys   []recipientTransientInput `json:"wrappedKeys"` // the file key wrapped for each recipient, alternative to encryptionKey
+	Ciphertext    *fileDigest               `json:"ciphertext"`  // optional, hash and size of the encrypted file
+	Plaintext     *fileDigest               `json:"plaintext"`   // optional, hash and size of the decrypted file
+}
+
+// bundleAccess lists which files of a bundle have been opened
+type bundleAccess struct {
+	Name        string       `json:"name"` // name of the transfer
+	FileCount   int          `json:"fileCount"`
+	OpenedCount int          `json:"openedCount"`
+	Opened      []string     `json:"opened"`   // names of the files opened by at least one recipient
+	Unopened    []string     `json:"unopened"` // names of the files no recipient has opened yet
+	Files       []bundleItem `json:"files"`
+}
+
+// ===========================================================================================
+// parseBundleFiles validates the files of a new bundle and returns their manifest entries along
+// with their private details. Each file has its own location, digests and key, either a plaintext
+// encryptionKey or the file key wrapped for every recipient of the bundle
+// ===========================================================================================
+func parseBundleFiles(stub shim.ChaincodeStubInterface, files []bundleFileTransientInput, recipients []transferRecipient) ([]bundleItem, []bundleItemPrivateDetails, error) {
+	if len(files) > maxBundleFiles {
+		return nil, nil, fmt.Errorf("files field must contain at most %d files", maxBundleFiles)
+	}
+
+	items := make([]bundleItem, 0, len(files))
+	itemDetails := make([]bundleItemPrivateDetails, 0, len(files))
+	seen := make(map[string]bool)
+	for i, file := range files {
+		if len(file.Name) == 0 {
+			return nil, nil, fmt.Errorf("name field of file %d must be a non-empty string", i)
+		}
+		if seen[file.Name] {
+			return nil, nil, fmt.Errorf("file %d: %s is listed more than once", i, file.Name)
+		}
+		seen[file.Name] = true
+
+		location, address, err := parseTransferLocation(file.Address, file.Location)
+		if err != nil {
+			return nil, nil, fmt.Errorf("file %d: %s", i, err.Error())
+		}
+		ciphertextHash, ciphertextSize, err := parseFileDigest("ciphertext", file.Ciphertext)
+		if err != nil {
+			return nil, nil, fmt.Errorf("file %d: %s", i, err.Error())
+		}
+		plaintextHash, plaintextSize, err := parseFileDigest("plaintext", file.Plaintext)
+		if err != nil {
+			return nil, nil, fmt.Errorf("file %d: %s", i, err.Error())
+		}
+
+		// the wrapped keys of a file must cover exactly the recipients of the bundle
+		keyInputs := file.WrappedKeys
+		if len(keyInputs) == 0 {
+			for _, recipient := range recipients {
+				keyInputs = append(keyInputs, recipientTransientInput{Recipient: recipient.ID, RecipientMSP: recipient.MSPID})
+			}
+		} else {
+			keyRecipients, err := parseRecipientIdentities(keyInputs)
+			if err != nil {
+				return nil, nil, fmt.Errorf("file %d: %s", i, err.Error())
+			}
+			if len(keyRecipients) != len(recipients) {
+				return nil, nil, fmt.Errorf("file %d: wrappedKeys field must have one key for each recipient", i)
+			}
+			bundle := fileTransfer{Recipients: recipients}
+			for _, keyRecipient := range keyRecipients {
+				if bundle.findRecipient(clientIdentity{MSPID: keyRecipient.MSPID, ID: keyRecipient.ID}) < 0 {
+					return nil, nil, fmt.Errorf("file %d: %s::%s is not a recipient of the transfer", i, keyRecipient.MSPID, keyRecipient.ID)
+				}
+			}
+		}
+		_, wrappedKeys, err := parseRecipients(stub, keyInputs, file.EncryptionKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("file %d: %s", i, err.Error())
+		}
+
+		items = append(items, bundleItem{
+			Name:           file.Name,
+			Description:    file.Description,
+			CiphertextHash: ciphertextHash,
+			CiphertextSize: ciphertextSize,
+		})
+		itemDetails = append(itemDetails, bundleItemPrivateDetails{
+			Name:          file.Name,
+			Address:       address,
+			Location:      location,
+			EncryptionKey: file.EncryptionKey,
+			WrappedKeys:   wrappedKeys,
+			PlaintextHash: plaintextHash,
+			PlaintextSize: plaintextSize,
+		})
+	}
+	return items, itemDetails, nil
+}
+
+// isBundle returns true if the transfer is a bundle of files rather than a single file
+func (transfer fileTransfer) isBundle() bool {
+	return len(transfer.Items) != 0
+}
+
+// ===========================================================================================
+// selectBundleItems returns the indexes of the named files of a bundle, or of every file when no
+// names are given. Names may only be given for a bundle
+// ===========================================================================================
+func (transfer fileTransfer) selectBundleItems(names []string) ([]int, error) {
+	if !transfer.isBundle() {
+		if len(names) != 0 {
+			return nil, fmt.Errorf("Transfer %s is not a bundle", transfer.Name)
+		}
+		return nil, nil
+	}
+
+	if len(names) == 0 {
+		indexes := make([]int, len(transfer.Items))
+		for i := range transfer.Items {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+
+	indexes := []int{}
+	selected := make(map[int]bool)
+	for _, name := range names {
+		index := -1
+		for i, item := range transfer.Items {
+			if item.Name == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("Bundle %s has no file named %s", transfer.Name, name)
+		}
+		if !selected[index] {
+			selected[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, nil
+}
+
+// markItemAccessed records an access by a recipient to one file of a bundle
+func (transfer *fileTransfer) markItemAccessed(index int, accessor clientIdentity, at time.Time) {
+	item := &transfer.Items[index]
+	item.HasBeenAccessed = true
+	for i := range item.AccessedBy {
+		if item.AccessedBy[i].MSPID == accessor.MSPID && item.AccessedBy[i].ID == accessor.ID {
+			item.AccessedBy[i].AccessCount++
+			item.AccessedBy[i].LastAccessedAt = at
+			return
+		}
+	}
+	item.AccessedBy = append(item.AccessedBy, bundleItemAccess{
+		ID:              accessor.ID,
+		MSPID:           accessor.MSPID,
+		AccessCount:     1,
+		FirstAccessedAt: at,
+		LastAccessedAt:  at,
+	})
+}
+
+// ===========================================================================================
+// GetBundleAccess returns which files of a bundle have been opened by at least one recipient,
+// along with the manifest and the per recipient access tracking of each file
+// ===========================================================================================
+func (c *FileTransferContract) GetBundleAccess(ctx TransactionContextInterface, name string) (*bundleAccess, error) {
+	transfer, _, err := getCallerFileTransfer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !transfer.isBundle() {
+		return nil, fmt.Errorf("Transfer %s is not a bundle", name)
+	}
+
+	access := &bundleAccess{
+		Name:      name,
+		FileCount: len(transfer.Items),
+		Opened:    []string{},
+		Unopened:  []string{},
+		Files:     transfer.Items,
+	}
+	for _, item := range transfer.Items {
+		if item.HasBeenAccessed {
+			access.Opened = append(access.Opened, item.Name)
+		} else {
+			access.Unopened = append(access.Unopened, item.Name)
+		}
+	}
+	access.OpenedCount = len(access.Opened)
+	return access, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+)
+
+// maxCascadeBatchSize caps the number of transfers revokeAuthorizationCascade processes in one
+// transaction, so that its read and write sets stay within the limits of a single transaction
+const maxCascadeBatchSize = 100
+
+// cascadeSkippedTransfer is a transfer under a revoked authorization that could not be revoked
+type cascadeSkippedTransfer struct {
+	Name   string         `json:"name"`
+	Status transferStatus `json:"status"` // e.g. Expired, whose private details are already purged
+}
+
+// cascadeReport is the result of one batch of revokeAuthorizationCascade
+type cascadeReport struct {
+	Authorization string                   `json:"authorization"`
+	Revoked       []string                 `json:"revoked"`   // transfers revoked by this batch
+	Skipped       []cascadeSkippedTransfer `json:"skipped"`   // transfers already revoked or that cannot be revoked
+	Processed     int                      `json:"processed"` // number of transfers in this batch
+	Bookmark      string                   `json:"bookmark"`  // continuation token of the next batch, empty once every transfer has been processed
+	Done          bool                     `json:"done"`
+}
+
+// ===========================================================================================
+// RevokeAuthorizationCascade - revoke an authorization along with every transfer made under it.
+// The id and reason are passed in the transient map under the authorization_revoke key. Transfers
+// are read from the authorization~name index and processed in batches of at most batchSize: each
+// one is marked revoked and its private details are purged. When more transfers remain, the
+// report holds a bookmark that is passed back in the transient input to process the next batch.
+// Only a client of the issuing organization may revoke the authorization. The cascade reaches the
+// transfers kept in the collections the caller's organization is a member of, so once the
+// authorization is revoked, a client of any organization may run it, without a reason, to revoke
+// the transfers of the pairs of organizations the issuing organization is not part of
+// ===========================================================================================
+func (c *FileTransferContract) RevokeAuthorizationCascade(ctx TransactionContextInterface) (*cascadeReport, error) {
+	fmt.Println("- start revoke authorization cascade")
+
+	type cascadeTransientInput struct {
+		ID        string `json:"id"`
+		Reason    string `json:"reason"`
+		BatchSize int32  `json:"batchSize"` // optional, at most maxCascadeBatchSize, which is also the default
+		Bookmark  string `json:"bookmark"`  // continuation token returned by the previous batch, empty for the first one
+	}
+
+	stub := ctx.GetStub()
+
+	var cascadeInput cascadeTransientInput
+	err := getTransientInput(ctx, "authorization_revoke", &cascadeInput)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cascadeInput.ID) == 0 {
+		return nil, fmt.Errorf("id field must be a non-empty string")
+	}
+	if cascadeInput.BatchSize == 0 {
+		cascadeInput.BatchSize = maxCascadeBatchSize
+	} else if cascadeInput.BatchSize < 0 || cascadeInput.BatchSize > maxCascadeBatchSize {
+		return nil, fmt.Errorf("batchSize field must be a number between 1 and %d", maxCascadeBatchSize)
+	}
+
+	record, err := getAuthorization(stub, cascadeInput.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first batch revokes the authorization itself, so that no new transfer can be made under it
+	// while the following batches run
+	caller := ctx.GetCaller()
+	if record.Status != authorizationRevoked {
+		if caller.MSPID != record.IssuerMSP {
+			return nil, fmt.Errorf("Only the issuing organization may revoke the authorization: %s", record.ID)
+		}
+		if len(cascadeInput.Reason) == 0 {
+			return nil, fmt.Errorf("reason field must be a non-empty string")
+		}
+		err = markAuthorizationRevoked(stub, record, cascadeInput.Reason, caller)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	records, bookmark, err := getFileTransfersByAuthorization(stub, caller.MSPID, record.ID, cascadeInput.BatchSize, cascadeInput.Bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &cascadeReport{
+		Authorization: record.ID,
+		Revoked:       []string{},
+		Skipped:       []cascadeSkippedTransfer{},
+		Processed:     len(records),
+		Bookmark:      bookmark,
+		Done:          len(bookmark) == 0,
+	}
+	// the transfers of every pair are revoked with the reason the authorization was revoked for
+	reason := fmt.Sprintf("Authorization %s revoked: %s", record.ID, record.RevocationReason)
+	for _, result := range records {
+		transfer := *result.Record
+		transfer.normalize()
+		if !canTransition(transfer.Status, statusRevoked) {
+			report.Skipped = append(report.Skipped, cascadeSkippedTransfer{Name: transfer.Name, Status: transfer.Status})
+			continue
+		}
+		collections, err := getTransferCollections(stub, caller, transfer.Name)
+		if err != nil {
+			return nil, err
+		}
+		err = revokeTransfer(stub, collections, &transfer, reason, caller)
+		if err != nil {
+			return nil, err
+		}
+		report.Revoked = append(report.Revoked, transfer.Name)
+	}
+
+	if len(report.Revoked) != 0 {
+		err = setTransferEvent(stub, &transferEvent{Type: eventTransfersRevoked, Names: report.Revoked, Authorization: record.ID}, caller)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("- end revoke authorization cascade (success), revoked %d transfers\n", len(report.Revoked))
+	return report, nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// =======Private data collections =============================================================
+// Each transfer is kept in the collections of the pair of organizations it is shared between,
+// the originator's and the recipients', e.g. transfer_Org1MSP_Org3MSP and
+// transferPrivateDetails_Org1MSP_Org3MSP, so that no other organization holds a copy of it.
+// A transfer with recipients in several other organizations is copied to the collections of the
+// originator's organization paired with each of them, every change being written to every copy,
+// and each caller reads the copy of a collection their organization is a member of.
+// The organizations of a transfer are recorded in world state under the hash of its name, which
+// is how a transfer is found from its name and what keeps names unique across collections, and the
+// pairs each organization belongs to are recorded so that queries can run over all of its collections.
+// Transfers saved before per pair collections were introduced stay in collectionFileTransfer and
+// collectionFileTransferPrivateDetails.
+// The collections config matching a set of organizations is printed by running the chaincode
+// binary with the collections-config argument, see printCollectionsConfig
+// ============================================================================================
+
+// transferDirectoryIndex is the composite key object type of the world state entries recording
+// the organizations of each transfer, keyed by the SHA-256 of the transfer name
+const transferDirectoryIndex = "transferDirectory~nameHash"
+
+// transferPairIndex is the composite key object type of the world state entries recording each
+// pair of organizations that has a transfer, keyed by msp~counterpart in both orders
+const transferPairIndex = "transferPair~msp~counterpart"
+
+// collectionMSPIDPattern matches the MSP IDs that can be part of a collection name. Underscores are
+// excluded as they separate the MSP IDs in the name
+var collectionMSPIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// transferCollections are the private data collections a transfer is kept in, along with the
+// organizations that are members of them
+type transferCollections struct {
+	Transfers      string                // the transfer, its index entries, access log, history and receipts
+	PrivateDetails string                // the address and key of the file, and the previous locations of the history
+	MSPIDs         []string              // the member organizations
+	Copies         []transferCollections // the collections of every pair a transfer with recipients in several other organizations is copied to, these included
+}
+
+// legacyCollections are the collections of the transfers saved before per pair collections were
+// introduced, shared by the organizations of the original collections_config.json
+var legacyCollections = transferCollections{
+	Transfers:      "collectionFileTransfer",
+	PrivateDetails: "collectionFileTransferPrivateDetails",
+	MSPIDs:         []string{"Org1MSP", "Org2MSP"},
+}
+
+// transferDirectoryEntry records the organizations a transfer is shared between
+type transferDirectoryEntry struct {
+	MSPIDs []string `json:"mspIds"` // the originator's organization then the others of the recipients, the same one twice for a transfer within an organization
+}
+
+// covers returns true if the organization is a member of the collections
+func (collections transferCollections) covers(mspID string) bool {
+	return containsString(collections.MSPIDs, mspID)
+}
+
+// copies returns the collections of every pair the transfer is kept in
+func (collections transferCollections) copies() []transferCollections {
+	if len(collections.Copies) == 0 {
+		return []transferCollections{collections}
+	}
+	return collections.Copies
+}
+
+// resolve returns the copy of the transfer in the collections of a pair the organization is a
+// member of, through which every copy is still written
+func (collections transferCollections) resolve(mspID string) (transferCollections, bool) {
+	for _, pair := range collections.copies() {
+		if pair.covers(mspID) {
+			pair.Copies = collections.Copies
+			return pair, true
+		}
+	}
+	return transferCollections{}, false
+}
+
+// organizations returns the organizations the transfer is shared between, sorted
+func (collections transferCollections) organizations() []string {
+	mspIDs := []string{}
+	for _, pair := range collections.copies() {
+		for _, mspID := range pair.MSPIDs {
+			if !containsString(mspIDs, mspID) {
+				mspIDs = append(mspIDs, mspID)
+			}
+		}
+	}
+	sort.Strings(mspIDs)
+	return mspIDs
+}
+
+// putTransfers writes a key of the transfer to the Transfers collection of every copy
+func (collections transferCollections) putTransfers(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+	for _, pair := range collections.copies() {
+		err := stub.PutPrivateData(pair.Transfers, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delTransfers deletes a key of the transfer from the Transfers collection of every copy
+func (collections transferCollections) delTransfers(stub shim.ChaincodeStubInterface, key string) error {
+	for _, pair := range collections.copies() {
+		err := stub.DelPrivateData(pair.Transfers, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putPrivateDetails writes a key of the transfer to the PrivateDetails collection of every copy
+func (collections transferCollections) putPrivateDetails(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+	for _, pair := range collections.copies() {
+		err := stub.PutPrivateData(pair.PrivateDetails, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delPrivateDetails deletes a key of the transfer from the PrivateDetails collection of every copy
+func (collections transferCollections) delPrivateDetails(stub shim.ChaincodeStubInterface, key string) error {
+	for _, pair := range collections.copies() {
+		err := stub.DelPrivateData(pair.PrivateDetails, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===========================================================================================
+// newPairCollections returns the collections shared by a pair of organizations. The MSP IDs are
+// sorted so that both orders give the same collections
+// ===========================================================================================
+func newPairCollections(mspID string, counterpartMSPID string) (transferCollections, error) {
+	for _, id := range []string{mspID, counterpartMSPID} {
+		if !collectionMSPIDPattern.MatchString(id) {
+			return transferCollections{}, fmt.Errorf("MSP ID %s cannot be part of a collection name, it may only contain letters, digits and dashes", id)
+		}
+	}
+	mspIDs := []string{mspID, counterpartMSPID}
+	sort.Strings(mspIDs)
+	return transferCollections{
+		Transfers:      fmt.Sprintf("transfer_%s_%s", mspIDs[0], mspIDs[1]),
+		PrivateDetails: fmt.Sprintf("transferPrivateDetails_%s_%s", mspIDs[0], mspIDs[1]),
+		MSPIDs:         mspIDs,
+	}, nil
+}
+
+// ===========================================================================================
+// getTransferOrganizations returns the organizations a new transfer is shared between: the
+// originator's, then the other organizations of its recipients, sorted, or the originator's
+// again if every recipient is in it
+// ===========================================================================================
+func getTransferOrganizations(originatorMSP string, recipients []transferRecipient) []string {
+	counterparts := []string{}
+	for _, recipient := range recipients {
+		if recipient.MSPID != originatorMSP && !containsString(counterparts, recipient.MSPID) {
+			counterparts = append(counterparts, recipient.MSPID)
+		}
+	}
+	if len(counterparts) == 0 {
+		return []string{originatorMSP, originatorMSP}
+	}
+	sort.Strings(counterparts)
+	return append([]string{originatorMSP}, counterparts...)
+}
+
+// ===========================================================================================
+// newTransferCollections returns the collections of a transfer shared between the passed in
+// organizations, the originator's first: those of the originator's organization paired with each
+// of the others. A transfer shared with a single other organization has no copies
+// ===========================================================================================
+func newTransferCollections(mspIDs []string) (transferCollections, error) {
+	var copies []transferCollections
+	for _, counterpartMSPID := range mspIDs[1:] {
+		collections, err := newPairCollections(mspIDs[0], counterpartMSPID)
+		if err != nil {
+			return transferCollections{}, err
+		}
+		copies = append(copies, collections)
+	}
+	if len(copies) == 1 {
+		return copies[0], nil
+	}
+	collections := copies[0]
+	collections.Copies = copies
+	return collections, nil
+}
+
+// getTransferDirectoryKey returns the key of the directory entry of a transfer
+func getTransferDirectoryKey(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	nameHash := sha256.Sum256([]byte(name))
+	return stub.CreateCompositeKey(transferDirectoryIndex, []string{hex.EncodeToString(nameHash[:])})
+}
+
+// ===========================================================================================
+// lookupTransferCollections returns the collections a transfer is kept in, whoever asks.
+// A transfer with no directory entry was saved in the legacy collections, or does not exist
+// ===========================================================================================
+func lookupTransferCollections(stub shim.ChaincodeStubInterface, name string) (transferCollections, bool, error) {
+	directoryKey, err := getTransferDirectoryKey(stub, name)
+	if err != nil {
+		return transferCollections{}, false, err
+	}
+	entryAsBytes, err := stub.GetState(directoryKey)
+	if err != nil {
+		return transferCollections{}, false, fmt.Errorf("Failed to get transfer directory entry: %s", err.Error())
+	} else if entryAsBytes == nil {
+		return legacyCollections, false, nil
+	}
+
+	var entry transferDirectoryEntry
+	err = json.Unmarshal(entryAsBytes, &entry)
+	if err != nil || len(entry.MSPIDs) < 2 {
+		return transferCollections{}, false, fmt.Errorf("Failed to decode JSON of: %s", string(entryAsBytes))
+	}
+	collections, err := newTransferCollections(entry.MSPIDs)
+	return collections, true, err
+}
+
+// ===========================================================================================
+// getTransferCollections returns the collections a transfer is kept in, resolved for the caller.
+// Only the members of the collections can read them, so the caller's organization must be one
+// of the organizations the transfer is shared between
+// ===========================================================================================
+func getTransferCollections(stub shim.ChaincodeStubInterface, caller clientIdentity, name string) (transferCollections, error) {
+	collections, _, err := lookupTransferCollections(stub, name)
+	if err != nil {
+		return transferCollections{}, err
+	}
+	resolved, ok := collections.resolve(caller.MSPID)
+	if !ok {
+		return transferCollections{}, fmt.Errorf("Transfer %s is not shared with %s", name, caller.MSPID)
+	}
+	return resolved, nil
+}
+
+// ===========================================================================================
+// getCallerFileTransfer resolves the collections of a transfer for the caller and reads the
+// transfer from them
+// ===========================================================================================
+func getCallerFileTransfer(ctx TransactionContextInterface, name string) (fileTransfer, transferCollections, error) {
+	collections, err := getTransferCollections(ctx.GetStub(), ctx.GetCaller(), name)
+	if err != nil {
+		return fileTransfer{}, collections, err
+	}
+	transfer, err := getFileTransfer(ctx.GetStub(), collections, name)
+	return transfer, collections, err
+}
+
+// ===========================================================================================
+// transferExists returns true if a transfer with the name exists in any collection. Transfers in
+// the legacy collections have no directory entry, so they are checked through the hash of their
+// record, which every peer of the channel holds, so that the organizations that are not members
+// of the legacy collections cannot take the name of a legacy transfer and hide it
+// ===========================================================================================
+func transferExists(stub shim.ChaincodeStubInterface, name string) (bool, error) {
+	_, found, err := lookupTransferCollections(stub, name)
+	if err != nil || found {
+		return found, err
+	}
+	transferHash, err := stub.GetPrivateDataHash(legacyCollections.Transfers, name)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get transfer: %s", err.Error())
+	}
+	return transferHash != nil, nil
+}
+
+// ===========================================================================================
+// registerTransferCollections writes the directory entry of a new transfer shared between the
+// passed in organizations, the originator's first, and records the pair of organizations of each
+// copy so that queries of both organizations include its collections
+// ===========================================================================================
+func registerTransferCollections(stub shim.ChaincodeStubInterface, name string, mspIDs []string) error {
+	collections, err := newTransferCollections(mspIDs)
+	if err != nil {
+		return err
+	}
+	entryJSONasBytes, err := json.Marshal(&transferDirectoryEntry{MSPIDs: mspIDs})
+	if err != nil {
+		return err
+	}
+	directoryKey, err := getTransferDirectoryKey(stub, name)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(directoryKey, entryJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range collections.copies() {
+		for i, mspID := range pair.MSPIDs {
+			pairKey, err := stub.CreateCompositeKey(transferPairIndex, []string{mspID, pair.MSPIDs[1-i]})
+			if err != nil {
+				return err
+			}
+			err = stub.PutState(pairKey, []byte{0x00})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unregisterTransferCollections deletes the directory entry of a deleted transfer, so its name can be used again
+func unregisterTransferCollections(stub shim.ChaincodeStubInterface, name string) error {
+	directoryKey, err := getTransferDirectoryKey(stub, name)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(directoryKey)
+}
+
+// ===========================================================================================
+// getMemberCollections returns every collection of transfers the organization is a member of:
+// those of each pair it has a transfer with, and the legacy collections if it is one of their
+// members. The collections are ordered by name so that every peer iterates them in the same order
+// ===========================================================================================
+func getMemberCollections(stub shim.ChaincodeStubInterface, mspID string) ([]transferCollections, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(transferPairIndex, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	memberCollections := []transferCollections{}
+	if legacyCollections.covers(mspID) {
+		memberCollections = append(memberCollections, legacyCollections)
+	}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		collections, err := newPairCollections(compositeKeyParts[0], compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		memberCollections = append(memberCollections, collections)
+	}
+
+	sort.Slice(memberCollections, func(i, j int) bool {
+		return memberCollections[i].Transfers < memberCollections[j].Transfers
+	})
+	return memberCollections, nil
+}
+
+// collectionConfig is one entry of the collections config of the chaincode definition
+type collectionConfig struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	RequiredPeerCount int    `json:"requiredPeerCount"`
+	MaxPeerCount      int    `json:"maxPeerCount"`
+	BlockToLive       uint64 `json:"blockToLive"`
+	MemberOnlyRead    bool   `json:"memberOnlyRead"`
+}
+
+// newCollectionConfig returns the config of a collection shared by the passed in organizations
+func newCollectionConfig(name string, mspIDs []string) collectionConfig {
+	members := ""
+	for i, mspID := range mspIDs {
+		if i != 0 {
+			members += ", "
+		}
+		members += fmt.Sprintf("'%s.member'", mspID)
+	}
+	return collectionConfig{
+		Name:              name,
+		Policy:            fmt.Sprintf("OR(%s)", members),
+		RequiredPeerCount: 0,
+		MaxPeerCount:      3,
+		BlockToLive:       0,
+		MemberOnlyRead:    true,
+	}
+}
+
+// ===========================================================================================
+// generateCollectionsConfig returns the collections config for a channel of the passed in
+// organizations: the legacy collections, which can't be removed from a chaincode definition once
+// defined, then the collections of every pair of organizations, including each organization with
+// itself for transfers within an organization
+// ===========================================================================================
+func generateCollectionsConfig(mspIDs []string) ([]collectionConfig, error) {
+	if len(mspIDs) == 0 {
+		return nil, fmt.Errorf("at least one MSP ID must be given")
+	}
+	sorted := append([]string{}, mspIDs...)
+	sort.Strings(sorted)
+
+	config := []collectionConfig{
+		newCollectionConfig(legacyCollections.Transfers, legacyCollections.MSPIDs),
+		newCollectionConfig(legacyCollections.PrivateDetails, legacyCollections.MSPIDs),
+	}
+	for i, mspID := range sorted {
+		if i != 0 && mspID == sorted[i-1] {
+			return nil, fmt.Errorf("MSP ID %s is given more than once", mspID)
+		}
+		for _, counterpart := range sorted[i:] {
+			collections, err := newPairCollections(mspID, counterpart)
+			if err != nil {
+				return nil, err
+			}
+			members := collections.MSPIDs
+			if mspID == counterpart {
+				members = members[:1]
+			}
+			config = append(config,
+				newCollectionConfig(collections.Transfers, members),
+				newCollectionConfig(collections.PrivateDetails, members))
+		}
+	}
+	return config, nil
+}
+
+// ===========================================================================================
+// printCollectionsConfig prints the collections config for a channel of the passed in
+// organizations, to be saved as the collections_config.json of the chaincode definition:
+// ./fileTransfer collections-config Org1MSP Org2MSP Org3MSP > collections_config.json
+// The CouchDB indexes in META-INF/statedb/couchdb/collections/collectionFileTransfer must be
+// packaged for each transfer_* collection as well
+// ===========================================================================================
+func printCollectionsConfig(mspIDs []string) error {
+	config, err := generateCollectionsConfig(mspIDs)
+	if err != nil {
+		return err
+	}
+	configJSONasBytes, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(configJSONasBytes))
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FileTransferContract holds the transactions of the file transfer chaincode. Every exported
+// method is a transaction, described in the metadata returned by org.hyperledger.fabric:GetMetadata,
+// so helpers must not be exported
+type FileTransferContract struct {
+	contractapi.Contract
+}
+
+// TransactionContextInterface is the transaction context passed to every transaction of the
+// contract. Along with the stub, it holds the identity of the submitter, read once per transaction
+type TransactionContextInterface interface {
+	contractapi.TransactionContextInterface
+	GetCaller() clientIdentity
+	SetCaller(caller clientIdentity)
+}
+
+// TransactionContext implements TransactionContextInterface
+type TransactionContext struct {
+	contractapi.TransactionContext
+	caller clientIdentity
+}
+
+// GetCaller returns the identity of the submitter of the transaction
+func (ctx *TransactionContext) GetCaller() clientIdentity {
+	return ctx.caller
+}
+
+// SetCaller sets the identity of the submitter of the transaction
+func (ctx *TransactionContext) SetCaller(caller clientIdentity) {
+	ctx.caller = caller
+}
+
+// newFileTransferContract returns the contract with its transaction context and hooks set up
+func newFileTransferContract() *FileTransferContract {
+	contract := new(FileTransferContract)
+	contract.Info.Title = "File transfer"
+	contract.Info.Description = "Record file transfers between organizations, keeping the file location and key in private data"
+	contract.Info.Version = "2.0.0"
+	contract.TransactionContextHandler = new(TransactionContext)
+	contract.BeforeTransaction = beforeTransaction
+	contract.UnknownTransaction = contract.invokeLegacyFunction
+	return contract
+}
+
+// ===========================================================================================
+// beforeTransaction runs before every transaction. It reads the submitter's identity from the
+// signed proposal, rather than trusting anything passed in the arguments or transient map,
+// so that transactions can use ctx.GetCaller()
+// ===========================================================================================
+func beforeTransaction(ctx TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	fmt.Println("invoke is running " + function)
+
+	caller, err := getClientIdentity(ctx.GetClientIdentity())
+	if err != nil {
+		return err
+	}
+	ctx.SetCaller(caller)
+	return nil
+}
+
+// ===========================================================================================
+// getTransientInput decodes the JSON value of a transient map key into input. Private data is
+// always passed in the transient map, so that it is not recorded in the transaction
+// ===========================================================================================
+func getTransientInput(ctx TransactionContextInterface, key string, input interface{}) error {
+	transMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("Error getting transient: %s", err.Error())
+	}
+
+	if _, ok := transMap[key]; !ok {
+		return fmt.Errorf("%s must be a key in the transient map", key)
+	}
+
+	if len(transMap[key]) == 0 {
+		return fmt.Errorf("%s value in the transient map must be a non-empty JSON string", key)
+	}
+
+	err = json.Unmarshal(transMap[key], input)
+	if err != nil {
+		return fmt.Errorf("Failed to decode JSON of: %s", string(transMap[key]))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ==================================================
+// ConfirmDelivery - confirm that the encrypted file of a transfer has been delivered to a
+// recipient, e.g. once their client has fetched it from its address, before it is opened.
+// The transfer moves to Delivered until one of its recipients accesses the file.
+// The name is passed in the transient map under the transfer_delivery key
+// ==================================================
+func (c *FileTransferContract) ConfirmDelivery(ctx TransactionContextInterface) error {
+	fmt.Println("- start confirm delivery")
+
+	type transferDeliveryTransientInput struct {
+		Name string `json:"name"`
+	}
+
+	stub := ctx.GetStub()
+
+	var deliveryInput transferDeliveryTransientInput
+	err := getTransientInput(ctx, "transfer_delivery", &deliveryInput)
+	if err != nil {
+		return err
+	}
+
+	if len(deliveryInput.Name) == 0 {
+		return fmt.Errorf("name field must be a non-empty string")
+	}
+
+	transfer, collections, err := getCallerFileTransfer(ctx, deliveryInput.Name)
+	if err != nil {
+		return err
+	}
+
+	// only the recipients of the transfer may confirm its delivery
+	caller := ctx.GetCaller()
+	if transfer.findRecipient(caller) < 0 {
+		return fmt.Errorf("Only the recipients of the transfer may confirm its delivery: %s", deliveryInput.Name)
+	}
+
+	// this is rejected once the file has been accessed, or if the transfer has been withdrawn
+	err = transitionFileTransfer(stub, &transfer, statusDelivered, caller)
+	if err != nil {
+		return err
+	}
+
+	transferJSONasBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	err = collections.putTransfers(stub, transfer.Name, transferJSONasBytes) //rewrite the transfer
+	if err != nil {
+		return err
+	}
+
+	err = emitTransferEvent(stub, eventFileDelivered, transfer.Name, caller)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("- end confirm delivery (success)")
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// transferEventVersion is the version of the transferEvent payload. Bump it whenever
+// a field is removed or changes meaning, so listeners can tell payloads apart
+const transferEventVersion = 1
+
+// Chaincode event names, which are also the type in the event payload
+const (
+	eventTransferCreated     = "FileTransferCreated"
+	eventFileDelivered       = "FileDelivered"
+	eventFileAccessed        = "FileAccessed"
+	eventTransferUpdated     = "FileTransferUpdated"
+	eventTransferSuperseded  = "FileTransferSuperseded"
+	eventTransferRevoked     = "FileTransferRevoked"
+	eventTransferDeleted     = "FileTransferDeleted"
+	eventTransfersExpired    = "FileTransfersExpired"
+	eventTransfersRevoked    = "FileTransfersRevoked"
+	eventReceiptAcknowledged = "FileReceiptAcknowledged"
+)
+
+// transferEvent is the payload of the chaincode events emitted by the handlers that change a transfer.
+// Chaincode events are visible to every listener on the channel, so the payload must never hold
+// private details such as the address or encryption key of the file
+type transferEvent struct {
+	Version       int       `json:"version"`
+	Type          string    `json:"type"`
+	Name          string    `json:"name,omitempty" metadata:",optional"`          // name of the transfer
+	Names         []string  `json:"names,omitempty" metadata:",optional"`         // names of the transfers, for events about several transfers
+	Previous      string    `json:"previous,omitempty" metadata:",optional"`      // name of the superseded transfer, for FileTransferSuperseded
+	Authorization string    `json:"authorization,omitempty" metadata:",optional"` // revoked authorization, for FileTransfersRevoked
+	Actor         string    `json:"actor"`                                        // client ID of the submitter of the transaction
+	ActorMSP      string    `json:"actorMSP"`                                     // MSP ID of the submitter of the transaction
+	TxID          string    `json:"txId"`
+	TxTime        time.Time `json:"txTime"`
+}
+
+// emitTransferEvent emits an event about a single transfer
+func emitTransferEvent(stub shim.ChaincodeStubInterface, eventType string, name string, actor clientIdentity) error {
+	return setTransferEvent(stub, &transferEvent{Type: eventType, Name: name}, actor)
+}
+
+// emitTransfersEvent emits an event about several transfers
+func emitTransfersEvent(stub shim.ChaincodeStubInterface, eventType string, names []string, actor clientIdentity) error {
+	return setTransferEvent(stub, &transferEvent{Type: eventType, Names: names}, actor)
+}
+
+// ===========================================================================================
+// setTransferEvent sets the chaincode event of the transaction. Only one event is delivered
+// per transaction, so each handler emits a single event once it has made all its changes
+// ===========================================================================================
+func setTransferEvent(stub shim.ChaincodeStubInterface, event *transferEvent, actor clientIdentity) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	event.Version = transferEventVersion
+	event.Actor = actor.ID
+	event.ActorMSP = actor.MSPID
+	event.TxID = stub.GetTxID()
+	event.TxTime = txTime
+
+	eventJSONasBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(event.Type, eventJSONasBytes)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// isBeforeWindow returns true if the transfer has a notBefore time that has not been reached yet
+func (transfer fileTransfer) isBeforeWindow(now time.Time) bool {
+	return !transfer.NotBefore.IsZero() && now.Before(transfer.NotBefore)
+}
+
+// isExpired returns true if the transfer has an expiresAt time that has been reached
+func (transfer fileTransfer) isExpired(now time.Time) bool {
+	return !transfer.ExpiresAt.IsZero() && !now.Before(transfer.ExpiresAt)
+}
+
+// ===========================================================================================
+// checkTransferWindow returns an error if the file of a transfer may not be retrieved at the
+// time of the current transaction. The transaction timestamp is used rather than the peer's
+// clock so that every endorsing peer reaches the same decision
+// ===========================================================================================
+func checkTransferWindow(stub shim.ChaincodeStubInterface, transfer fileTransfer) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	if transfer.isBeforeWindow(txTime) {
+		return fmt.Errorf("Transfer %s is not retrievable before %s", transfer.Name, transfer.NotBefore.Format(time.RFC3339))
+	}
+	if transfer.isExpired(txTime) {
+		return fmt.Errorf("Transfer %s expired at %s", transfer.Name, transfer.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ===========================================================================================
+// PurgeExpiredTransfers marks every transfer whose expiresAt time has passed as expired and
+// deletes its private details and the previous file locations of its history, so the address and
+// encryption key are no longer available.
+// Transfers are found with a range query over each collection of transfers the caller's
+// organization is a member of, which skips composite key index entries and works on LevelDB as
+// well as CouchDB. Transfers of the collections of other organizations are purged by them.
+// Returns the names of the transfers that were purged.
+// ===========================================================================================
+func (c *FileTransferContract) PurgeExpiredTransfers(ctx TransactionContextInterface) ([]string, error) {
+	fmt.Println("- start purgeExpiredTransfers")
+
+	stub := ctx.GetStub()
+	caller := ctx.GetCaller()
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	memberCollections, err := getMemberCollections(stub, caller.MSPID)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	for _, member := range memberCollections {
+		expiredTransfers, err := getExpiredTransfers(stub, member, txTime)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, transfer := range expiredTransfers {
+			// a transfer with recipients in several other organizations is found in each of its copies
+			if containsString(purged, transfer.Name) {
+				continue
+			}
+			collections, err := getTransferCollections(stub, caller, transfer.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			err = transitionFileTransfer(stub, &transfer, statusExpired, caller)
+			if err != nil {
+				return nil, err
+			}
+
+			transferJSONasBytes, err := json.Marshal(transfer)
+			if err != nil {
+				return nil, err
+			}
+			err = collections.putTransfers(stub, transfer.Name, transferJSONasBytes) //rewrite the transfer
+			if err != nil {
+				return nil, err
+			}
+
+			err = collections.delPrivateDetails(stub, transfer.Name)
+			if err != nil {
+				return nil, err
+			}
+			err = collections.deletePrivateDetailsByPartialCompositeKey(stub, revisionIndex, []string{transfer.Name})
+			if err != nil {
+				return nil, err
+			}
+			err = deleteTransferKeys(stub, collections, transfer)
+			if err != nil {
+				return nil, err
+			}
+			purged = append(purged, transfer.Name)
+		}
+	}
+
+	if len(purged) != 0 {
+		err = emitTransfersEvent(stub, eventTransfersExpired, purged, caller)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("- end purgeExpiredTransfers, purged %d transfers\n", len(purged))
+	return purged, nil
+}
+
+// getExpiredTransfers returns the transfers of a collection that have expired and can still be marked as expired
+func getExpiredTransfers(stub shim.ChaincodeStubInterface, collections transferCollections, txTime time.Time) ([]fileTransfer, error) {
+	resultsIterator, err := stub.GetPrivateDataByRange(collections.Transfers, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var expiredTransfers []fileTransfer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var transfer fileTransfer
+		err = json.Unmarshal(queryResponse.Value, &transfer)
+		if err != nil || transfer.ObjectType != "fileTransfer" {
+			continue
+		}
+		if transfer.isExpired(txTime) && canTransition(transfer.Status, statusExpired) {
+			expiredTransfers = append(expiredTransfers, transfer)
+		}
+	}
+	return expiredTransfers, nil
+}
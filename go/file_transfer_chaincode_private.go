@@ -1,21 +1,100 @@
 // ====CHAINCODE EXECUTION SAMPLES (CLI) ==================
 
+// The chaincode is a FileTransferContract (see contract.go) built on fabric-contract-api-go. Its transactions
+// are the exported methods of the contract, e.g. InitFileTransfer or QueryFileTransferByStatusWithPagination,
+// and are described by the metadata returned by org.hyperledger.fabric:GetMetadata:
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["org.hyperledger.fabric:GetMetadata"]}'
+// The lowercase function names used below are still accepted and are mapped onto the transactions (see legacy.go).
+
+// ==== Collections config, for the transfer_<MSP>_<MSP> collections of every pair of organizations of the channel ====
+// ./fileTransfer collections-config Org1MSP Org2MSP Org3MSP > collections_config.json
+
 // ==== Invoke transfers, pass private data as base64 encoded bytes in transient map ====
+// The originator is taken from the submitter's certificate. The recipient is identified by their MSP ID
+// and their client ID, which is the value returned by cid.GetID for the recipient's certificate, i.e.
+// base64("x509::<subject DN>::<issuer DN>")
+//
+// The authorization of a transfer must first be registered, by a client of the issuing organization:
+// export AUTHORIZATION=$(echo -n "{\"id\":\"auth1\",\"scope\":\"court order 2019/42\",\"originatorMSPs\":[\"Org1MSP\"],\"recipientMSPs\":[\"Org2MSP\"]}" | base64 | tr -d \\n)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["createAuthorization"]}' --transient "{\"authorization\":\"$AUTHORIZATION\"}"
 //
-// export TRANSFER=$(echo -n "{\"name\":\"transfer1\",\"description\":\"first transfer\",\"originator\":\"alice\",\"recipient\":\"bob\",\"authorization\":\"auth1\",\"address\":\"file-is-here\",\"encryptionKey\":\"secret\"}" | base64 | tr -d \\n)
+// export TRANSFER=$(echo -n "{\"name\":\"transfer1\",\"description\":\"first transfer\",\"recipient\":\"$RECIPIENT_ID\",\"recipientMSP\":\"Org2MSP\",\"authorization\":\"auth1\",\"address\":\"/ipfs/$CID/report.pdf\",\"encryptionKey\":\"secret\"}" | base64 | tr -d \\n)
 // peer chaincode invoke -o orderer.example.com:7050 --tls --cafile /opt/gopath/src/github.com/hyperledger/fabric/peer/crypto/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem -C mychannel -n fileTransfer -c '{"Args":["initFileTransfer"]}' --transient "{\"fileTransfer\":\"$TRANSFER\"}"
 //
+// Instead of encryptionKey, the file key can be wrapped for the recipient's registered public key, so the plaintext key never reaches the ledger:
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["registerPublicKey","-----BEGIN PUBLIC KEY-----\n...\n-----END PUBLIC KEY-----"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getPublicKey","Org2MSP","'$RECIPIENT_ID'"]}'
+// export TRANSFER=$(echo -n "{\"name\":\"transfer2\",\"description\":\"wrapped key\",\"recipient\":\"$RECIPIENT_ID\",\"recipientMSP\":\"Org2MSP\",\"authorization\":\"auth1\",\"address\":\"/ipfs/$CID/report.pdf\",\"wrappedKey\":{\"algorithm\":\"RSA-OAEP-SHA256\",\"keyFingerprint\":\"$FINGERPRINT\",\"ciphertext\":\"$WRAPPED_KEY\"}}" | base64 | tr -d \\n)
+//
+// With "keyDelivery":"implicit", the keys are delivered to the implicit collection of the recipient's organization only,
+// so the transaction must also be endorsed by one of its peers:
+// peer chaincode invoke -C mychannel -n fileTransfer --peerAddresses peer0.org1.example.com:7051 --peerAddresses peer0.org2.example.com:9051 -c '{"Args":["initFileTransfer"]}' --transient "{\"fileTransfer\":\"$TRANSFER\"}"
+//
+// Files stored outside IPFS are given by a typed location instead of an address, e.g.
+// \"location\":{\"scheme\":\"s3\",\"bucket\":\"reports\",\"key\":\"2020/q1.enc\",\"region\":\"eu-west-1\"}
+//
+// A transfer can have several recipients, each with their own wrapped key:
+// export TRANSFER=$(echo -n "{\"name\":\"transfer3\",\"description\":\"two recipients\",\"recipients\":[{\"recipient\":\"$RECIPIENT_ID\",\"recipientMSP\":\"Org2MSP\",\"wrappedKey\":$WRAPPED_KEY_1},{\"recipient\":\"$OTHER_RECIPIENT_ID\",\"recipientMSP\":\"Org1MSP\",\"wrappedKey\":$WRAPPED_KEY_2}],\"authorization\":\"auth1\",\"address\":\"/ipfs/$CID/report.pdf\"}" | base64 | tr -d \\n)
+//
+// The SHA-256 hash and size of the encrypted and decrypted file can be registered with a transfer, adding e.g.
+// \"ciphertext\":{\"hash\":\"$CIPHERTEXT_SHA256\",\"size\":1024},\"plaintext\":{\"hash\":\"$PLAINTEXT_SHA256\",\"size\":1000}
+// to the transfer input. A recipient can then check the file they fetched from the address:
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["verifyFileIntegrity","transfer1","'$CIPHERTEXT_SHA256'"]}'
+//
+// export TRANSFER_UPDATE=$(echo -n "{\"name\":\"transfer1\",\"description\":\"corrected description\"}" | base64)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["updateFileTransfer"]}' --transient "{\"transfer_update\":\"$TRANSFER_UPDATE\"}"
+//
+// A new version of a document supersedes the transfer named in previousName, which can then no longer be accessed:
+// export TRANSFER=$(echo -n "{\"name\":\"transfer1-v2\",\"previousName\":\"transfer1\",...}" | base64 | tr -d \\n)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["supersedeFileTransfer"]}' --transient "{\"fileTransfer\":\"$TRANSFER\"}"
+//
+// A bundle sends several files as one transfer, each with its own address, key and digests, and accessFile can name the files that were opened:
+// export TRANSFER=$(echo -n "{\"name\":\"bundle1\",...,\"files\":[{\"name\":\"summary.pdf\",\"address\":\"$CID_1\",\"encryptionKey\":\"secret1\"},{\"name\":\"figures.xlsx\",\"address\":\"$CID_2\",\"encryptionKey\":\"secret2\"}]}" | base64 | tr -d \\n)
+// export TRANSFER_FLAG=$(echo -n "{\"name\":\"bundle1\",\"files\":[\"summary.pdf\"]}" | base64)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["accessFile"]}' --transient "{\"transfer_flag\":\"$TRANSFER_FLAG\"}"
+//
 // export TRANSFER_DELETE=$(echo -n "{\"name\":\"transfer1\"}" | base64)
 // peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["delete"]}' --transient "{\"transfer_delete\":\"$TRANSFER_DELETE\"}"
+//
+// export TRANSFER_REVOKE=$(echo -n "{\"name\":\"transfer1\",\"reason\":\"sent in error\"}" | base64)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["revokeFileTransfer"]}' --transient "{\"transfer_revoke\":\"$TRANSFER_REVOKE\"}"
+//
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["purgeExpiredTransfers"]}'
+//
+// Revoking an authorization with revokeAuthorizationCascade also revokes every transfer made under it, in batches.
+// Pass the bookmark of the returned report back in the input until the report is done:
+// export AUTHORIZATION_REVOKE=$(echo -n "{\"id\":\"auth1\",\"reason\":\"order quashed\",\"batchSize\":50}" | base64)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["revokeAuthorizationCascade"]}' --transient "{\"authorization_revoke\":\"$AUTHORIZATION_REVOKE\"}"
+//
+// After accessing the file, a recipient acknowledges it with a signature, made with their registered key, over "<name>\n<contentHash>\n<timestamp>":
+// export TRANSFER_RECEIPT=$(echo -n "{\"name\":\"transfer1\",\"contentHash\":\"$CONTENT_SHA256\",\"timestamp\":\"2019-06-01T12:00:00Z\",\"signature\":\"$SIGNATURE\"}" | base64 | tr -d \\n)
+// peer chaincode invoke -C mychannel -n fileTransfer -c '{"Args":["acknowledgeReceipt"]}' --transient "{\"transfer_receipt\":\"$TRANSFER_RECEIPT\"}"
 
 // ==== Query marbles, since queries are not recorded on chain we don't need to hide private data in transient map ====
 // peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["readFileTransfer","transfer1"]}'
 // peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["readFileTransferPrivateDetails","transfer1"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getAccessLog","transfer1"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getTransferHistory","transfer1"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getTransferLineage","transfer1"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getBundleAccess","bundle1"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getAuthorization","auth1"]}'
+// peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getReceipt","transfer1","Org2MSP","'$RECIPIENT_ID'"]}'
 // peer chaincode query -C mychannel -n marblesp -c '{"Args":["getMarblesByRange","marble1","marble4"]}'
 //
 // Rich Query (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByOriginator","tom"]}'
-//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfers","{\"selector\":{\"owner\":\"tom\"}}"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByOriginator","Org1MSP","'$ORIGINATOR_ID'"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByRecipient","Org2MSP","'$RECIPIENT_ID'"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByStatus","Accessed"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfers","{\"selector\":{\"docType\":\"fileTransfer\",\"authorization\":\"auth1\"}}"]}'
+//
+// Composite key query (supported on any state database):
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByAuthorization","auth1"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["getTransfersByAuthorization","auth1"]}'
+//
+// Paginated queries take a page size and a bookmark, which is empty for the first page, and return {records, fetchedCount, bookmark}:
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByStatusWithPagination","Created","10",""]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfersWithPagination","{\"selector\":{\"docType\":\"fileTransfer\"}}","10","transfer10"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryFileTransferByAuthorizationWithPagination","auth1","10",""]}'
 
 // INDEXES TO SUPPORT COUCHDB RICH QUERIES
 //
@@ -61,180 +140,286 @@
 // Index definition for use with Fauxton interface
 // {"index":{"fields":["data.docType","data.owner"]},"ddoc":"indexOwnerDoc", "name":"indexOwner","type":"json"}
 
-// Index for docType, owner, size (descending order).
-// Note that docType, owner and size fields must be prefixed with the "data" wrapper
+// Index for docType, statusChangedAt (descending order).
+// Note that docType and statusChangedAt fields must be prefixed with the "data" wrapper
 //
 // Index definition for use with Fauxton interface
-// {"index":{"fields":[{"data.size":"desc"},{"data.docType":"desc"},{"data.owner":"desc"}]},"ddoc":"indexSizeSortDoc", "name":"indexSizeSortDesc","type":"json"}
+// {"index":{"fields":[{"data.statusChangedAt":"desc"},{"data.docType":"desc"}]},"ddoc":"indexStatusChangedSortDoc", "name":"indexStatusChangedSortDesc","type":"json"}
+
+// Indexes for docType, originatorMSP, originator and for docType, status, which support
+// queryFileTransferByOriginator and queryFileTransferByStatus, are packaged in
+// META-INF/statedb/couchdb/collections/collectionFileTransfer/indexes, and in the indexes
+// directory of each transfer_<MSP>_<MSP> collection of the organizations of the channel.
+//
+// Index definitions for use with Fauxton interface
+// {"index":{"fields":["data.docType","data.originatorMSP","data.originator"]},"ddoc":"indexOriginatorDoc", "name":"indexOriginator","type":"json"}
+// {"index":{"fields":["data.docType","data.status"]},"ddoc":"indexStatusDoc", "name":"indexStatus","type":"json"}
 
 // Rich Query with index design doc and index name specified (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfers","{\"selector\":{\"docType\":\"transfer\",\"originator\":\"Alice\"}, \"use_index\":[\"_design/indexOwnerDoc\", \"indexOwner\"]}"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfers","{\"selector\":{\"docType\":\"fileTransfer\",\"status\":\"Created\"}, \"use_index\":[\"_design/indexStatusDoc\", \"indexStatus\"]}"]}'
 
 // Rich Query with index design doc specified only (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfers","{\"selector\":{\"docType\":{\"$eq\":\"transfer\"},\"originator\":{\"$eq\":\"alice\"},\"size\":{\"$gt\":0}},\"fields\":[\"docType\",\"originator\",\"size\"],\"sort\":[{\"size\":\"desc\"}],\"use_index\":\"_design/indexSizeSortDoc\"}"]}'
+//   peer chaincode query -C mychannel -n fileTransfer -c '{"Args":["queryTransfers","{\"selector\":{\"docType\":{\"$eq\":\"fileTransfer\"},\"status\":{\"$eq\":\"Accessed\"},\"statusChangedAt\":{\"$gt\":\"2019-01-01T00:00:00Z\"}},\"fields\":[\"name\",\"status\",\"statusChangedAt\"],\"sort\":[{\"statusChangedAt\":\"desc\"}],\"use_index\":\"_design/indexStatusChangedSortDoc\"}"]}'
+//
+// Ad hoc queries may only select, sort and return the fields of fileTransfer, using the $eq, $ne, $gt, $gte, $lt,
+// $lte, $in, $nin, $exists, $and, $or, $nor and $not operators ($elemMatch on recipients). The selector is
+// always restricted to docType fileTransfer and the limit is capped at 100 records.
 
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 )
 
-// SimpleChaincode example simple Chaincode implementation
-type SimpleChaincode struct {
-}
-
 type fileTransfer struct {
 	ObjectType      string `json:"docType"` //docType is used to distinguish the various types of objects in state database
 	Name            string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
 	Description     string `json:"description"`
-	Originator      string `json:"originator"`
-	Recipient       string `json:"recipient"`
+	Originator      string `json:"originator"`                                  // client ID of the originator, taken from the submitter's certificate
+	OriginatorMSP   string `json:"originatorMSP"`                               // MSP ID of the originator, taken from the submitter's certificate
+	Recipient       string `json:"recipient,omitempty" metadata:",optional"`    // client ID of the recipient of a transfer saved before multiple recipients were supported
+	RecipientMSP    string `json:"recipientMSP,omitempty" metadata:",optional"` // MSP ID of the recipient of a transfer saved before multiple recipients were supported
 	Authorization   string `json:"authorization"`
-	HasBeenAccessed bool   `json:hasBeenAccessed`
+	HasBeenAccessed bool   `json:"hasBeenAccessed"` // true once any of the recipients has accessed the file
+
+	Recipients []transferRecipient `json:"recipients"`
+
+	NotBefore time.Time `json:"notBefore"` // the file may not be retrieved before this time, zero if retrievable at once
+	ExpiresAt time.Time `json:"expiresAt"` // the file may not be retrieved from this time on, zero if it does not expire
+
+	Revision int `json:"revision"` // number of times the transfer has been updated, see updateFileTransfer
+
+	Version      int    `json:"version"`                                     // version of the document, 1 unless the transfer supersedes another
+	PreviousName string `json:"previousName,omitempty" metadata:",optional"` // name of the transfer this one supersedes
+	SupersededBy string `json:"supersededBy,omitempty" metadata:",optional"` // name of the transfer that supersedes this one
+
+	CiphertextHash string `json:"ciphertextHash,omitempty" metadata:",optional"` // hex SHA-256 of the encrypted file at the address
+	CiphertextSize int64  `json:"ciphertextSize,omitempty" metadata:",optional"` // size in bytes of the encrypted file at the address
+
+	Items []bundleItem `json:"items,omitempty" metadata:",optional"` // manifest of the files of a bundle, empty for a single file
+
+	KeyDelivery string `json:"keyDelivery,omitempty" metadata:",optional"` // shared or implicit, see key_delivery.go
+
+	Status             transferStatus `json:"status"`             // lifecycle status, only changed by transitionFileTransfer
+	StatusChangedBy    string         `json:"statusChangedBy"`    // client ID of whoever last changed the status
+	StatusChangedByMSP string         `json:"statusChangedByMSP"` // MSP ID of whoever last changed the status
+	StatusChangedAt    time.Time      `json:"statusChangedAt"`    // transaction timestamp of the last status change
+
+	RevocationReason string    `json:"revocationReason,omitempty" metadata:",optional"`
+	RevokedBy        string    `json:"revokedBy,omitempty" metadata:",optional"`
+	RevokedByMSP     string    `json:"revokedByMSP,omitempty" metadata:",optional"`
+	RevokedAt        time.Time `json:"revokedAt"` // zero unless revoked
 }
 
 type fileTransferPrivateDetails struct {
-	ObjectType    string `json:"docType"`       //docType is used to distinguish the various types of objects in state database
-	Name          string `json:"name"`          //the fieldtags are needed to keep case from bouncing around
-	Address       string `json:"address"`       // address of the product in the ipfs filesystem
-	EncryptionKey string `json:"encryptionKey"` // encryption key for the file
+	ObjectType    string                `json:"docType"`                                      //docType is used to distinguish the various types of objects in state database
+	Name          string                `json:"name"`                                         //the fieldtags are needed to keep case from bouncing around
+	Address       string                `json:"address"`                                      // address of the file, as submitted or rendered from the location
+	Location      *storageLocator       `json:"location"`                                     // typed location of the file, read as ipfs for transfers saved with only an address
+	EncryptionKey string                `json:"encryptionKey,omitempty" metadata:",optional"` // encryption key for the file, empty if the key is wrapped
+	WrappedKeys   []recipientWrappedKey `json:"wrappedKeys,omitempty" metadata:",optional"`   // encryption key for the file, wrapped for each recipient's public key
+	PlaintextHash string                `json:"plaintextHash,omitempty" metadata:",optional"` // hex SHA-256 of the decrypted file, kept private as it could identify a guessable file
+	PlaintextSize int64                 `json:"plaintextSize,omitempty" metadata:",optional"` // size in bytes of the decrypted file
+
+	Items []bundleItemPrivateDetails `json:"items,omitempty" metadata:",optional"` // address and key of each file of a bundle, in manifest order
+
+	KeyHashes map[string]string `json:"keyHashes,omitempty" metadata:",optional"` // hex SHA-256 of the key material delivered to each recipient organization, by MSP ID, when the keys are delivered to implicit collections
+}
+
+// transferTransientInput is a new transfer, as passed in the transient map
+type transferTransientInput struct {
+	Name          string                     `json:"name"` //the fieldtags are needed to keep case from bouncing around
+	Description   string                     `json:"description"`
+	Recipients    []recipientTransientInput  `json:"recipients"`
+	Recipient     string                     `json:"recipient"`    // shorthand for a single recipient
+	RecipientMSP  string                     `json:"recipientMSP"` // shorthand for a single recipient
+	Authorization string                     `json:"authorization"`
+	Address       string                     `json:"address"`  // IPFS CID of the file, shorthand for an ipfs location
+	Location      *storageLocator            `json:"location"` // alternative to address
+	EncryptionKey string                     `json:"encryptionKey"`
+	WrappedKey    *wrappedKey                `json:"wrappedKey"`   // shorthand for a single recipient, alternative to encryptionKey
+	NotBefore     time.Time                  `json:"notBefore"`    // optional, RFC 3339
+	ExpiresAt     time.Time                  `json:"expiresAt"`    // optional, RFC 3339
+	Ciphertext    *fileDigest                `json:"ciphertext"`   // optional, hash and size of the encrypted file
+	Plaintext     *fileDigest                `json:"plaintext"`    // optional, hash and size of the decrypted file
+	PreviousName  string                     `json:"previousName"` // name of the transfer to supersede, only for supersedeFileTransfer
+	Files         []bundleFileTransientInput `json:"files"`        // files of a bundle, each with its own address, key and digests
+	KeyDelivery   string                     `json:"keyDelivery"`  // optional, shared (default) or implicit to deliver the keys to the recipient organizations only
 }
 
 // ===================================================================================
 // Main
 // ===================================================================================
 func main() {
-	err := shim.Start(new(SimpleChaincode))
-	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
-	}
-}
-
-// Init initializes chaincode
-// ===========================
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	return shim.Success(nil)
-}
-
-// Invoke - Our entry point for Invocations
-// ========================================
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	switch function {
-	case "initFileTransfer":
-		//create a new file transfer
-		return t.initFileTransfer(stub, args)
-	case "readFileTransfer":
-		//read a file transfer
-		return t.readFileTransfer(stub, args)
-	case "readFileTransferPrivateDetails":
-		//read a file transfer private details
-		return t.readFileTransferPrivateDetails(stub, args)
-	/*case "transferMarble":
-	//change owner of a specific marble
-	return t.transferMarble(stub, args)*/
-	case "delete":
-		//delete a file transfer
-		return t.delete(stub, args)
-	case "queryFileTransferByOriginator":
-		//find transfer for owner X using rich query
-		return t.queryFileTransferByOriginator(stub, args)
-	case "queryTransfers":
-		//find transfers based on an ad hoc rich query
-		return t.queryTransfers(stub, args)
-	/*case "getMarblesByRange":
-	//get marbles based on range query
-	return t.getMarblesByRange(stub, args)*/
-	case "accessFile":
-		// get the file and mark is as having been accessed by the recipient
-		return t.accessFile(stub, args)
-	default:
-		//error
-		fmt.Println("invoke did not find func: " + function)
-		return shim.Error("Received unknown function invocation")
+	// the collections config of a channel is printed rather than the chaincode started when asked for
+	if len(os.Args) > 1 && os.Args[1] == "collections-config" {
+		err := printCollectionsConfig(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error generating collections config: %s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-}
 
-// ============================================================
-// initFileTransfer - create a new file transfer, store into chaincode state
-// ============================================================
-func (t *SimpleChaincode) initFileTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
+	chaincode, err := newFileTransferChaincode()
+	if err != nil {
+		fmt.Printf("Error creating file transfer chaincode: %s", err)
+		return
+	}
 
-	type transferTransientInput struct {
-		Name          string `json:"name"` //the fieldtags are needed to keep case from bouncing around
-		Description   string `json:"description"`
-		Originator    string `json:"originator"`
-		Recipient     string `json:"recipient"`
-		Authorization string `json:"authorization"`
-		Address       string `json:"address"` // address of the product in the ipfs filesystem
-		EncryptionKey string `json:"encryptionKey"`
+	err = chaincode.Start()
+	if err != nil {
+		fmt.Printf("Error starting file transfer chaincode: %s", err)
 	}
+}
 
+// ============================================================
+// InitFileTransfer - create a new transfer, store into chaincode state.
+// The transfer is passed in the transient map under the fileTransfer key
+// ============================================================
+func (c *FileTransferContract) InitFileTransfer(ctx TransactionContextInterface) error {
 	// ==== Input sanitation ====
 	fmt.Println("- start init transfer")
 
-	if len(args) != 0 {
-		return shim.Error("Incorrect number of arguments. Private transfer data must be passed in transient map.")
-	}
-
-	transMap, err := stub.GetTransient()
+	var transferInput transferTransientInput
+	err := getTransientInput(ctx, "fileTransfer", &transferInput)
 	if err != nil {
-		return shim.Error("Error getting transient: " + err.Error())
+		return err
 	}
-
-	if _, ok := transMap["fileTransfer"]; !ok {
-		return shim.Error("fileTransfer must be a key in the transient map")
+	if len(transferInput.PreviousName) != 0 {
+		return fmt.Errorf("previousName field must be empty, use supersedeFileTransfer to supersede a transfer")
 	}
 
-	if len(transMap["fileTransfer"]) == 0 {
-		return shim.Error("fileTransfer value in the transient map must be a non-empty JSON string")
+	transfer, err := createFileTransfer(ctx, transferInput, nil)
+	if err != nil {
+		return err
 	}
 
-	var transferInput transferTransientInput
-	err = json.Unmarshal(transMap["fileTransfer"], &transferInput)
+	// ==== Let listeners know about the new transfer, without any of its private details ====
+	err = emitTransferEvent(ctx.GetStub(), eventTransferCreated, transfer.Name, ctx.GetCaller())
 	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(transMap["fileTransfer"]))
+		return err
 	}
 
+	// ==== Transfer saved and indexed. Return success ====
+	fmt.Println("- end init transfer")
+	return nil
+}
+
+// ===========================================================================================
+// createFileTransfer validates the input of a new transfer, then saves the transfer, its private
+// details and its authorization~name index entry in the collections of the originator's and the
+// recipients' organizations. A transfer that supersedes a previous one is linked to it and gets
+// the next version
+// ===========================================================================================
+func createFileTransfer(ctx TransactionContextInterface, transferInput transferTransientInput, previous *fileTransfer) (*fileTransfer, error) {
+	stub := ctx.GetStub()
+
 	if len(transferInput.Name) == 0 {
-		return shim.Error("name field must be a non-empty string")
+		return nil, fmt.Errorf("name field must be a non-empty string")
 	}
 	if len(transferInput.Description) == 0 {
-		return shim.Error("description field must be a non-empty string")
-	}
-	if len(transferInput.Originator) == 0 {
-		return shim.Error("originator field must be a non-empty string")
+		return nil, fmt.Errorf("description field must be a non-empty string")
 	}
-	if len(transferInput.Recipient) == 0 {
-		return shim.Error("recipient field must be a non-empty string")
+	recipientInputs, err := normalizeRecipientInputs(transferInput.Recipients, transferInput.Recipient, transferInput.RecipientMSP, transferInput.WrappedKey)
+	if err != nil {
+		return nil, err
 	}
 	if len(transferInput.Authorization) == 0 {
-		return shim.Error("authorization field must be a non-empty string")
+		return nil, fmt.Errorf("authorization field must be a non-empty string")
 	}
-	if len(transferInput.Address) == 0 {
-		return shim.Error("address field must be a non-empty string")
+	keyDelivery, err := parseKeyDelivery(transferInput.KeyDelivery)
+	if err != nil {
+		return nil, err
 	}
-	if len(transferInput.EncryptionKey) == 0 {
-		return shim.Error("encryptionKey field must be a non-empty string")
+
+	// a bundle has an address, key and digests for each of its files instead of a single set
+	var location *storageLocator
+	var address, ciphertextHash, plaintextHash string
+	var ciphertextSize, plaintextSize int64
+	var recipients []transferRecipient
+	var wrappedKeys []recipientWrappedKey
+	var items []bundleItem
+	var itemDetails []bundleItemPrivateDetails
+	if len(transferInput.Files) != 0 {
+		if len(transferInput.Address) != 0 || transferInput.Location != nil || len(transferInput.EncryptionKey) != 0 ||
+			transferInput.Ciphertext != nil || transferInput.Plaintext != nil {
+			return nil, fmt.Errorf("address, location, encryptionKey, ciphertext and plaintext fields must be empty when files is given")
+		}
+		for i, input := range recipientInputs {
+			if input.WrappedKey != nil {
+				return nil, fmt.Errorf("recipient %d: wrappedKey must be given for each of the files when files is given", i)
+			}
+		}
+		recipients, err = parseRecipientIdentities(recipientInputs)
+		if err != nil {
+			return nil, err
+		}
+		items, itemDetails, err = parseBundleFiles(stub, transferInput.Files, recipients)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		location, address, err = parseTransferLocation(transferInput.Address, transferInput.Location)
+		if err != nil {
+			return nil, err
+		}
+		ciphertextHash, ciphertextSize, err = parseFileDigest("ciphertext", transferInput.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		plaintextHash, plaintextSize, err = parseFileDigest("plaintext", transferInput.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		recipients, wrappedKeys, err = parseRecipients(stub, recipientInputs, transferInput.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !transferInput.NotBefore.IsZero() && !transferInput.ExpiresAt.IsZero() && !transferInput.ExpiresAt.After(transferInput.NotBefore) {
+		return nil, fmt.Errorf("expiresAt field must be later than notBefore")
+	}
+	if !transferInput.ExpiresAt.IsZero() {
+		txTime, err := getTxTime(stub)
+		if err != nil {
+			return nil, err
+		}
+		if !transferInput.ExpiresAt.After(txTime) {
+			return nil, fmt.Errorf("expiresAt field must be in the future")
+		}
+	}
+
+	// ==== The originator is whoever submitted the transaction, never the transient payload ====
+	originator := ctx.GetCaller()
+
+	// ==== The transfer is kept in the collections of the originator's and the recipients' organizations ====
+	mspIDs := getTransferOrganizations(originator.MSPID, recipients)
+	collections, err := newTransferCollections(mspIDs)
+	if err != nil {
+		return nil, err
 	}
 
 	// ==== Check if transfer already exists ====
-	transferAsBytes, err := stub.GetPrivateData("collectionFileTransfer", transferInput.Name)
+	exists, err := transferExists(stub, transferInput.Name)
 	if err != nil {
-		return shim.Error("Failed to get transfer: " + err.Error())
-	} else if transferAsBytes != nil {
+		return nil, err
+	} else if exists {
 		fmt.Println("This transfer already exists: " + transferInput.Name)
-		return shim.Error("This transfer already exists: " + transferInput.Name)
+		return nil, fmt.Errorf("This transfer already exists: %s", transferInput.Name)
+	}
+
+	// ==== The authorization must be registered and cover the originator and every recipient ====
+	authorizationRecord, err := checkAuthorization(stub, transferInput.Authorization, originator.MSPID, recipients, true)
+	if err != nil {
+		return nil, err
 	}
 
 	// ==== Create transfer object, marshal to JSON, and save to state ====
@@ -242,36 +427,67 @@ func (t *SimpleChaincode) initFileTransfer(stub shim.ChaincodeStubInterface, arg
 		ObjectType:      "fileTransfer",
 		Name:            transferInput.Name,
 		Description:     transferInput.Description,
-		Originator:      transferInput.Originator,
-		Recipient:       transferInput.Recipient,
+		Originator:      originator.ID,
+		OriginatorMSP:   originator.MSPID,
+		Recipients:      recipients,
 		Authorization:   transferInput.Authorization,
 		HasBeenAccessed: false,
+		NotBefore:       transferInput.NotBefore,
+		ExpiresAt:       transferInput.ExpiresAt,
+		CiphertextHash:  ciphertextHash,
+		CiphertextSize:  ciphertextSize,
+		Items:           items,
+		Version:         1,
+		KeyDelivery:     keyDelivery,
+	}
+	if previous != nil {
+		transfer.PreviousName = previous.Name
+		transfer.Version = previous.Version + 1
+	}
+	err = transitionFileTransfer(stub, transfer, statusCreated, originator)
+	if err != nil {
+		return nil, err
 	}
 	transferJSONasBytes, err := json.Marshal(transfer)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 
 	// === Save transfer to state ===
-	err = stub.PutPrivateData("collectionFileTransfer", transferInput.Name, transferJSONasBytes)
+	err = collections.putTransfers(stub, transferInput.Name, transferJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
+	}
+	err = registerTransferCollections(stub, transferInput.Name, mspIDs)
+	if err != nil {
+		return nil, err
 	}
 
 	// ==== Create transfer private details object with price, marshal to JSON, and save to state ====
 	transferPrivateDetails := &fileTransferPrivateDetails{
 		ObjectType:    "fileTransferPrivateDetails",
 		Name:          transferInput.Name,
-		Address:       transferInput.Address,
+		Address:       address,
+		Location:      location,
 		EncryptionKey: transferInput.EncryptionKey,
+		WrappedKeys:   wrappedKeys,
+		PlaintextHash: plaintextHash,
+		PlaintextSize: plaintextSize,
+		Items:         itemDetails,
+	}
+	if keyDelivery == keyDeliveryImplicit {
+		err = deliverTransferKeys(stub, *transfer, transferPrivateDetails)
+		if err != nil {
+			return nil, err
+		}
 	}
 	transferPrivateDetailsBytes, err := json.Marshal(transferPrivateDetails)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	err = stub.PutPrivateData("collectionFileTransferPrivateDetails", transferInput.Name, transferPrivateDetailsBytes)
+	err = collections.putPrivateDetails(stub, transferInput.Name, transferPrivateDetailsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 
 	//  ==== Index the transfer to enable Authorization range queries, e.g. return all transfers under the same authorization ====
@@ -279,338 +495,402 @@ func (t *SimpleChaincode) initFileTransfer(stub shim.ChaincodeStubInterface, arg
 	//  The key is a composite key, with the elements that you want to range query on listed first.
 	//  In our case, the composite key is based on indexName~authorization~name.
 	//  This will enable very efficient state range queries based on composite keys matching indexName~authorization~*
-	indexName := "authorization~name"
-	authorizationNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{transfer.Authorization, transfer.Name})
+	//  The collections of each pair store it as a simple key, see getAuthorizationNameIndexKey.
+	authorizationNameIndexKey, err := getAuthorizationNameIndexKey(stub, collections, transfer.Authorization, transfer.Name)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 	//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the marble.
 	//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
 	value := []byte{0x00}
-	stub.PutPrivateData("collectionFileTransfer", authorizationNameIndexKey, value)
+	err = collections.putTransfers(stub, authorizationNameIndexKey, value)
+	if err != nil {
+		return nil, err
+	}
 
-	// ==== Transfer saved and indexed. Return success ====
-	fmt.Println("- end init transfer")
-	return shim.Success(nil)
+	err = countAuthorizedTransfer(stub, authorizationRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
 }
 
 // ===============================================
-// readFileTransfer - read a transfer from chaincode state
+// ReadFileTransfer - read a transfer from chaincode state. The transfer is read from the
+// collections of the pair of organizations it is shared between, which must include the caller's
 // ===============================================
-func (t *SimpleChaincode) readFileTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the transfer to query")
-	}
-
-	name = args[0]
-	valAsbytes, err := stub.GetPrivateData("collectionFileTransfer", name) //get the transfer from chaincode state
+func (c *FileTransferContract) ReadFileTransfer(ctx TransactionContextInterface, name string) (*fileTransfer, error) {
+	transfer, _, err := getCallerFileTransfer(ctx, name)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Transfer does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return nil, err
 	}
-
-	return shim.Success(valAsbytes)
+	return &transfer, nil
 }
 
 // ===============================================
-// readFileTransferPrivateDetails - read a transfer private details from chaincode state
+// ReadFileTransferPrivateDetails - read a transfer private details from chaincode state. Only the
+// originator and the recipients of the transfer may read them, not every client of their
+// organizations. When the keys of the transfer were delivered to implicit collections, they are
+// read from the implicit collection of the caller's organization and checked against their hash
 // ===============================================
-func (t *SimpleChaincode) readFileTransferPrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
+func (c *FileTransferContract) ReadFileTransferPrivateDetails(ctx TransactionContextInterface, name string) (*fileTransferPrivateDetails, error) {
+	stub := ctx.GetStub()
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the transfer to query")
+	transfer, collections, err := getCallerFileTransfer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	caller := ctx.GetCaller()
+	if !caller.isOriginatorOf(transfer) && transfer.findRecipient(caller) < 0 {
+		return nil, fmt.Errorf("Only the originator and the recipients of the transfer may read its private details: %s", name)
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetPrivateData("collectionFileTransferPrivateDetails", name) //get the transfer private details from chaincode state
+	// refuse to hand out the address and key of a revoked transfer
+	if transfer.Status == statusRevoked {
+		return nil, fmt.Errorf("Transfer has been revoked: %s", name)
+	}
+	err = checkAuthorizationNotRevoked(stub, transfer.Authorization)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get private details for " + name + ": " + err.Error() + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble private details does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return nil, err
+	}
+	err = checkTransferWindow(stub, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	transferPrivateDetails, err := getFileTransferPrivateDetails(stub, collections, name)
+	if err != nil {
+		return nil, err
 	}
 
-	return shim.Success(valAsbytes)
+	// keys delivered to implicit collections are read from the one of the caller's organization
+	if transfer.KeyDelivery == keyDeliveryImplicit {
+		err = readTransferKeys(stub, caller, &transferPrivateDetails)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &transferPrivateDetails, nil
 }
 
 // ==================================================
-// delete - remove a transfer key/value pair from state
+// DeleteFileTransfer - remove a transfer key/value pair from state, along with its entry in the
+// directory of transfers so that its name can be used again, and the records kept under its name,
+// so that a new transfer with the same name does not inherit them. The name of the transfer is
+// passed in the transient map under the transfer_delete key
 // ==================================================
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (c *FileTransferContract) DeleteFileTransfer(ctx TransactionContextInterface) error {
 	fmt.Println("- start delete transfer")
 
 	type transferDeleteTransientInput struct {
 		Name string `json:"name"`
 	}
 
-	if len(args) != 0 {
-		return shim.Error("Incorrect number of arguments. Private transfer name must be passed in transient map.")
-	}
-
-	transMap, err := stub.GetTransient()
-	if err != nil {
-		return shim.Error("Error getting transient: " + err.Error())
-	}
-
-	if _, ok := transMap["transfer_delete"]; !ok {
-		return shim.Error("transfer_delete must be a key in the transient map")
-	}
-
-	if len(transMap["transfer_delete"]) == 0 {
-		return shim.Error("transfer_delete value in the transient map must be a non-empty JSON string")
-	}
+	stub := ctx.GetStub()
 
 	var transferDeleteInput transferDeleteTransientInput
-	err = json.Unmarshal(transMap["transfer_delete"], &transferDeleteInput)
+	err := getTransientInput(ctx, "transfer_delete", &transferDeleteInput)
 	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(transMap["transfer_delete"]))
+		return err
 	}
 
 	if len(transferDeleteInput.Name) == 0 {
-		return shim.Error("name field must be a non-empty string")
+		return fmt.Errorf("name field must be a non-empty string")
 	}
 
 	// to maintain the authorization~name index, we need to read the transfer first and get its authorization
-	valAsbytes, err := stub.GetPrivateData("collectionFileTransfer", transferDeleteInput.Name) //get the marble from chaincode state
+	transferToDelete, collections, err := getCallerFileTransfer(ctx, transferDeleteInput.Name)
 	if err != nil {
-		return shim.Error("Failed to get state for " + transferDeleteInput.Name)
-	} else if valAsbytes == nil {
-		return shim.Error("Transfer does not exist: " + transferDeleteInput.Name)
+		return err
+	}
+
+	// only the originator of the transfer may delete it
+	caller := ctx.GetCaller()
+	if !caller.isOriginatorOf(transferToDelete) {
+		return fmt.Errorf("Only the originator of the transfer may delete it: %s", transferDeleteInput.Name)
 	}
 
-	var transferToDelete fileTransfer
-	err = json.Unmarshal([]byte(valAsbytes), &transferToDelete)
+	// validate the move to Deleted, even though the record itself is removed below
+	err = transitionFileTransfer(stub, &transferToDelete, statusDeleted, caller)
 	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(valAsbytes))
+		return err
 	}
 
 	// delete the transfer from state
-	err = stub.DelPrivateData("collectionFileTransfer", transferDeleteInput.Name)
+	err = collections.delTransfers(stub, transferDeleteInput.Name)
+	if err != nil {
+		return fmt.Errorf("Failed to delete state: %s", err.Error())
+	}
+	err = unregisterTransferCollections(stub, transferDeleteInput.Name)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return fmt.Errorf("Failed to delete state: %s", err.Error())
 	}
 
 	// Also delete the transfer from the authorization~name index
-	indexName := "authorization~name"
-	authorizationNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{transferToDelete.Authorization, transferToDelete.Name})
+	authorizationNameIndexKey, err := getAuthorizationNameIndexKey(stub, collections, transferToDelete.Authorization, transferToDelete.Name)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	err = stub.DelPrivateData("collectionFileTransfer", authorizationNameIndexKey)
+	err = collections.delTransfers(stub, authorizationNameIndexKey)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return fmt.Errorf("Failed to delete state: %s", err.Error())
 	}
 
 	// Finally, delete private details of transfer
-	err = stub.DelPrivateData("collectionFileTransferPrivateDetails", transferDeleteInput.Name)
+	err = collections.delPrivateDetails(stub, transferDeleteInput.Name)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
+	}
+	err = deleteTransferKeys(stub, collections, transferToDelete)
+	if err != nil {
+		return err
 	}
 
-	return shim.Success(nil)
-}
-
-// ===========================================================
-// transfer a marble by setting a new owner name on the marble
-// ===========================================================
-/*func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// and the records kept under its name: its access log, the signed receipts of its recipients
+	// and its history, whose previous file locations are kept with the private details
+	for _, index := range []string{accessLogIndex, receiptIndex, revisionIndex} {
+		err = collections.deleteTransfersByPartialCompositeKey(stub, index, []string{transferDeleteInput.Name})
+		if err != nil {
+			return err
+		}
+	}
+	err = collections.deletePrivateDetailsByPartialCompositeKey(stub, revisionIndex, []string{transferDeleteInput.Name})
+	if err != nil {
+		return err
+	}
 
-	fmt.Println("- start transfer marble")
+	return emitTransferEvent(stub, eventTransferDeleted, transferDeleteInput.Name, caller)
+}
 
-	type marbleTransferTransientInput struct {
-		Name  string `json:"name"`
-		Owner string `json:"owner"`
+// deleteTransfersByPartialCompositeKey deletes the entries of the Transfers collection of every copy
+// whose composite keys start with the passed in attributes, e.g. the receipts of a transfer
+func (collections transferCollections) deleteTransfersByPartialCompositeKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string) error {
+	keys, err := getPrivateDataKeysByPartialCompositeKey(stub, collections.Transfers, objectType, attributes)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = collections.delTransfers(stub, key)
+		if err != nil {
+			return fmt.Errorf("Failed to delete state: %s", err.Error())
+		}
 	}
+	return nil
+}
 
-	if len(args) != 0 {
-		return shim.Error("Incorrect number of arguments. Private marble data must be passed in transient map.")
+// deletePrivateDetailsByPartialCompositeKey deletes the entries of the PrivateDetails collection of
+// every copy whose composite keys start with the passed in attributes, e.g. the previous file
+// locations of a transfer
+func (collections transferCollections) deletePrivateDetailsByPartialCompositeKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string) error {
+	keys, err := getPrivateDataKeysByPartialCompositeKey(stub, collections.PrivateDetails, objectType, attributes)
+	if err != nil {
+		return err
 	}
+	for _, key := range keys {
+		err = collections.delPrivateDetails(stub, key)
+		if err != nil {
+			return fmt.Errorf("Failed to delete state: %s", err.Error())
+		}
+	}
+	return nil
+}
 
-	transMap, err := stub.GetTransient()
+// getPrivateDataKeysByPartialCompositeKey returns the keys of the entries of a collection whose
+// composite keys start with the passed in attributes. The copies of a transfer hold the same keys,
+// so they are read from the copy the caller's organization is a member of
+func getPrivateDataKeysByPartialCompositeKey(stub shim.ChaincodeStubInterface, collection string, objectType string, attributes []string) ([]string, error) {
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, objectType, attributes)
 	if err != nil {
-		return shim.Error("Error getting transient: " + err.Error())
+		return nil, err
 	}
+	defer resultsIterator.Close()
 
-	if _, ok := transMap["marble_owner"]; !ok {
-		return shim.Error("marble_owner must be a key in the transient map")
+	var keys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, queryResponse.Key)
 	}
+	return keys, nil
+}
+
+// ===========================================================================================
+// getFileTransfer reads a transfer from the passed in collections.
+// Transfers saved before the status field was introduced have no status, so it is derived
+// from the HasBeenAccessed flag. Transfers saved before multiple recipients were supported
+// have their single recipient moved into the recipients list
+// ===========================================================================================
+func getFileTransfer(stub shim.ChaincodeStubInterface, collections transferCollections, name string) (fileTransfer, error) {
+	var transfer fileTransfer
 
-	if len(transMap["marble_owner"]) == 0 {
-		return shim.Error("marble_owner value in the transient map must be a non-empty JSON string")
+	transferAsBytes, err := stub.GetPrivateData(collections.Transfers, name)
+	if err != nil {
+		return transfer, fmt.Errorf("Failed to get transfer: %s", err.Error())
+	} else if transferAsBytes == nil {
+		return transfer, fmt.Errorf("Transfer does not exist: %s", name)
 	}
 
-	var marbleTransferInput marbleTransferTransientInput
-	err = json.Unmarshal(transMap["marble_owner"], &marbleTransferInput)
+	err = json.Unmarshal(transferAsBytes, &transfer) //unmarshal it aka JSON.parse()
 	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(transMap["marble_owner"]))
+		return transfer, fmt.Errorf("Failed to decode JSON of: %s", string(transferAsBytes))
 	}
+	transfer.normalize()
+
+	return transfer, nil
+}
 
-	if len(marbleTransferInput.Name) == 0 {
-		return shim.Error("name field must be a non-empty string")
+// normalize fills in the fields of a transfer saved by an earlier version of the chaincode
+func (transfer *fileTransfer) normalize() {
+	if transfer.Status == statusNew {
+		if transfer.HasBeenAccessed {
+			transfer.Status = statusAccessed
+		} else {
+			transfer.Status = statusCreated
+		}
 	}
-	if len(marbleTransferInput.Owner) == 0 {
-		return shim.Error("owner field must be a non-empty string")
+	if transfer.Version == 0 {
+		transfer.Version = 1
 	}
-
-	marbleAsBytes, err := stub.GetPrivateData("collectionMarbles", marbleTransferInput.Name)
-	if err != nil {
-		return shim.Error("Failed to get marble:" + err.Error())
-	} else if marbleAsBytes == nil {
-		return shim.Error("Marble does not exist: " + marbleTransferInput.Name)
+	if len(transfer.Recipients) == 0 && len(transfer.Recipient) != 0 {
+		transfer.Recipients = []transferRecipient{{
+			ID:              transfer.Recipient,
+			MSPID:           transfer.RecipientMSP,
+			HasBeenAccessed: transfer.HasBeenAccessed,
+		}}
+		transfer.Recipient = ""
+		transfer.RecipientMSP = ""
 	}
+}
 
-	marbleToTransfer := marble{}
-	err = json.Unmarshal(marbleAsBytes, &marbleToTransfer) //unmarshal it aka JSON.parse()
+// ===========================================================================================
+// getFileTransferPrivateDetails reads the private details of a transfer from the passed in
+// collections. Details saved with only a plain address are given an ipfs location
+// ===========================================================================================
+func getFileTransferPrivateDetails(stub shim.ChaincodeStubInterface, collections transferCollections, name string) (fileTransferPrivateDetails, error) {
+	var transferPrivateDetails fileTransferPrivateDetails
+
+	valAsbytes, err := stub.GetPrivateData(collections.PrivateDetails, name) //get the transfer private details from chaincode state
 	if err != nil {
-		return shim.Error(err.Error())
+		return transferPrivateDetails, fmt.Errorf("Failed to get private details for %s: %s", name, err.Error())
+	} else if valAsbytes == nil {
+		return transferPrivateDetails, fmt.Errorf("Transfer private details do not exist: %s", name)
 	}
-	marbleToTransfer.Owner = marbleTransferInput.Owner //change the owner
 
-	marbleJSONasBytes, _ := json.Marshal(marbleToTransfer)
-	err = stub.PutPrivateData("collectionMarbles", marbleToTransfer.Name, marbleJSONasBytes) //rewrite the marble
+	err = json.Unmarshal(valAsbytes, &transferPrivateDetails)
 	if err != nil {
-		return shim.Error(err.Error())
+		return transferPrivateDetails, fmt.Errorf("Failed to decode JSON of: %s", string(valAsbytes))
 	}
-
-	fmt.Println("- end transferMarble (success)")
-	return shim.Success(nil)
-}*/
+	if transferPrivateDetails.Location == nil && len(transferPrivateDetails.Items) == 0 {
+		transferPrivateDetails.Location = legacyStorageLocator(transferPrivateDetails.Address)
+	}
+	return transferPrivateDetails, nil
+}
 
 // ===========================================================
-// Record that a file has been accessed by the recipient by setting the HasBeenAccessed flag
+// AccessFile - record that a file has been accessed by one of the recipients by setting the
+// HasBeenAccessed flag of the transfer and of the recipient, and appending an entry to the
+// access log of the transfer. The name of the transfer is passed in the transient map under
+// the transfer_flag key. For a bundle, the names of the files that were opened may be given in
+// files, otherwise every file of the bundle is marked
 // ===========================================================
-func (t *SimpleChaincode) accessFile(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (c *FileTransferContract) AccessFile(ctx TransactionContextInterface) error {
 
 	fmt.Println("- start accessFile")
 
 	type fileAccessTransientInput struct {
-		Name            string `json:"name"`
-		HasBeenAccessed bool   `json:"hasBeenAccessed"`
+		Name            string   `json:"name"`
+		HasBeenAccessed bool     `json:"hasBeenAccessed"`
+		Files           []string `json:"files"` // optional, files of a bundle that were opened
 	}
 
-	if len(args) != 0 {
-		return shim.Error("Incorrect number of arguments. Private transfer data must be passed in transient map.")
-	}
+	stub := ctx.GetStub()
 
-	transMap, err := stub.GetTransient()
+	var accessTransferInput fileAccessTransientInput
+	err := getTransientInput(ctx, "transfer_flag", &accessTransferInput)
 	if err != nil {
-		return shim.Error("Error getting transient: " + err.Error())
+		return err
 	}
 
-	if _, ok := transMap["transfer_flag"]; !ok {
-		return shim.Error("transfer_flag must be a key in the transient map")
+	if len(accessTransferInput.Name) == 0 {
+		return fmt.Errorf("name field must be a non-empty string")
 	}
 
-	if len(transMap["transfer_flag"]) == 0 {
-		return shim.Error("transfer_flag value in the transient map must be a non-empty JSON string")
+	accessToTransfer, collections, err := getCallerFileTransfer(ctx, accessTransferInput.Name)
+	if err != nil {
+		return err
 	}
 
-	var accessTransferInput fileAccessTransientInput
-	err = json.Unmarshal(transMap["transfer_flag"], &accessTransferInput)
-	if err != nil {
-		return shim.Error("Failed to decode JSON of: " + string(transMap["transfer_flag"]))
+	// only the recipients of the transfer may access the file
+	caller := ctx.GetCaller()
+	recipientIndex := accessToTransfer.findRecipient(caller)
+	if recipientIndex < 0 {
+		return fmt.Errorf("Only the recipients of the transfer may access the file: %s", accessTransferInput.Name)
 	}
 
-	if len(accessTransferInput.Name) == 0 {
-		return shim.Error("name field must be a non-empty string")
+	// point recipients of an old version of a document to the version that replaced it
+	if accessToTransfer.Status == statusSuperseded {
+		return fmt.Errorf("Transfer %s has been superseded by %s", accessToTransfer.Name, accessToTransfer.SupersededBy)
 	}
 
-	transferAsBytes, err := stub.GetPrivateData("collectionFileTransfer", accessTransferInput.Name)
+	// the file may only be accessed within its retrieval window, if it has one
+	err = checkTransferWindow(stub, accessToTransfer)
 	if err != nil {
-		return shim.Error("Failed to get transfer:" + err.Error())
-	} else if transferAsBytes == nil {
-		return shim.Error("Transfer does not exist: " + accessTransferInput.Name)
+		return err
 	}
 
-	accessToTransfer := fileTransfer{}
-	err = json.Unmarshal(transferAsBytes, &accessToTransfer) //unmarshal it aka JSON.parse()
+	items, err := accessToTransfer.selectBundleItems(accessTransferInput.Files)
 	if err != nil {
-		return shim.Error(err.Error())
-	}
-	if accessToTransfer.HasBeenAccessed == true {
-		// The file has already been accessed.
-		// TODO: do we need to record the number of times it has been accessed and when?
-	} else {
-		// mark the file as having been accessed
-		accessToTransfer.HasBeenAccessed = true
+		return err
 	}
 
-	transferJSONasBytes, _ := json.Marshal(accessToTransfer)
-	err = stub.PutPrivateData("collectionFileTransfer", accessToTransfer.Name, transferJSONasBytes) //rewrite the marble
+	// mark the file as having been accessed, this is rejected if the transfer has been revoked or has expired.
+	// Once every recipient has acknowledged receipt the transfer stays Acknowledged, and further
+	// accesses, e.g. to files of a bundle opened since, are only recorded for the recipient and the files
+	if accessToTransfer.Status != statusAcknowledged {
+		err = transitionFileTransfer(stub, &accessToTransfer, statusAccessed, caller)
+		if err != nil {
+			return err
+		}
+	}
+	// or if its authorization has been revoked, and the cascade has not revoked the transfer yet
+	err = checkAuthorizationNotRevoked(stub, accessToTransfer.Authorization)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-
-	fmt.Println("- end accessFile (success)")
-	return shim.Success(nil)
-}
-
-// ===========================================================================================
-// getMarblesByRange performs a range query based on the start and end keys provided.
-
-// Read-only function results are not typically submitted to ordering. If the read-only
-// results are submitted to ordering, or if the query is used in an update transaction
-// and submitted to ordering, then the committing peers will re-execute to guarantee that
-// result sets are stable between endorsement time and commit time. The transaction is
-// invalidated by the committing peers if the result set has changed between endorsement
-// time and commit time.
-// Therefore, range queries are a safe option for performing update transactions based on query results.
-// ===========================================================================================
-/*func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	accessedAt, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	accessToTransfer.markRecipientAccessed(recipientIndex, accessedAt)
+	files := []string{}
+	for _, index := range items {
+		accessToTransfer.markItemAccessed(index, caller, accessedAt)
+		files = append(files, accessToTransfer.Items[index].Name)
 	}
 
-	startKey := args[0]
-	endKey := args[1]
-
-	resultsIterator, err := stub.GetPrivateDataByRange("collectionMarbles", startKey, endKey)
+	// record who accessed the file and when in the access log of the transfer
+	err = recordFileAccess(stub, collections, accessToTransfer.Name, caller, files)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
 
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	transferJSONasBytes, _ := json.Marshal(accessToTransfer)
+	err = collections.putTransfers(stub, accessToTransfer.Name, transferJSONasBytes) //rewrite the transfer
+	if err != nil {
+		return err
 	}
-	buffer.WriteString("]")
 
-	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", buffer.String())
+	err = emitTransferEvent(stub, eventFileAccessed, accessToTransfer.Name, caller)
+	if err != nil {
+		return err
+	}
 
-	return shim.Success(buffer.Bytes())
-}*/
+	fmt.Println("- end accessFile (success)")
+	return nil
+}
 
 // =======Rich queries =========================================================================
 // Two examples of rich queries are provided below (parameterized query and ad hoc query).
@@ -626,96 +906,370 @@ func (t *SimpleChaincode) accessFile(stub shim.ChaincodeStubInterface, args []st
 // ============================================================================================
 
 // ===== Example: Parameterized rich query =================================================
-// queryFileTransferByOriginator queries for transfers based on a passed in Originator.
+// QueryFileTransferByOriginator queries for transfers based on a passed in Originator.
 // This is an example of a parameterized query where the query logic is baked into the chaincode,
-// and accepting a single query parameter (owner).
+// and accepting the MSP ID and client ID of the originator as query parameters.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
-func (t *SimpleChaincode) queryFileTransferByOriginator(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (c *FileTransferContract) QueryFileTransferByOriginator(ctx TransactionContextInterface, originatorMSP string, originator string) ([]queryRecord, error) {
+	return getQueryResultForSelector(ctx.GetStub(), ctx.GetCaller().MSPID, originatorSelector(originatorMSP, originator))
+}
 
-	//   0
-	// "bob"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// originatorSelector selects the transfers sent by the passed in client
+func originatorSelector(originatorMSP string, originator string) map[string]interface{} {
+	return map[string]interface{}{
+		"docType":       "fileTransfer",
+		"originatorMSP": originatorMSP,
+		"originator":    originator,
 	}
+}
 
-	owner := strings.ToLower(args[0])
+// ===== Parameterized rich query ==========================================================
+// QueryFileTransferByRecipient queries for transfers that have the passed in client among their recipients.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByRecipient(ctx TransactionContextInterface, recipientMSP string, recipient string) ([]queryRecord, error) {
+	return getQueryResultForSelector(ctx.GetStub(), ctx.GetCaller().MSPID, recipientSelector(recipientMSP, recipient))
+}
 
-	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"transfer\",\"originator\":\"%s\"}}", owner)
+// recipientSelector selects the transfers that have the passed in client among their recipients.
+// Transfers saved before multiple recipients were supported have a single recipient field instead
+func recipientSelector(recipientMSP string, recipient string) map[string]interface{} {
+	return map[string]interface{}{
+		"docType": "fileTransfer",
+		"$or": []interface{}{
+			map[string]interface{}{
+				"recipients": map[string]interface{}{
+					"$elemMatch": map[string]interface{}{
+						"mspId": recipientMSP,
+						"id":    recipient,
+					},
+				},
+			},
+			map[string]interface{}{
+				"recipientMSP": recipientMSP,
+				"recipient":    recipient,
+			},
+		},
+	}
+}
+
+// ===== Parameterized rich query ==========================================================
+// QueryFileTransferByStatus queries for transfers based on a passed in lifecycle status.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByStatus(ctx TransactionContextInterface, status string) ([]queryRecord, error) {
+	transferStatus, err := parseTransferStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	return getQueryResultForSelector(ctx.GetStub(), ctx.GetCaller().MSPID, statusSelector(transferStatus))
+}
+
+// statusSelector selects the transfers with the passed in lifecycle status. Transfers saved before
+// the status was recorded have no status field; they are Created or Accessed depending on
+// hasBeenAccessed, as normalize reads them, so they are selected by that instead
+func statusSelector(status transferStatus) map[string]interface{} {
+	if status != statusCreated && status != statusAccessed {
+		return map[string]interface{}{
+			"docType": "fileTransfer",
+			"status":  status,
+		}
+	}
+	return map[string]interface{}{
+		"docType": "fileTransfer",
+		"$or": []interface{}{
+			map[string]interface{}{"status": status},
+			map[string]interface{}{
+				"status":          map[string]interface{}{"$exists": false},
+				"hasBeenAccessed": status == statusAccessed,
+			},
+		},
+	}
+}
 
-	queryResults, err := getQueryResultForQueryString(stub, queryString)
+// ===== Composite key query ===============================================================
+// QueryFileTransferByAuthorization queries for transfers sent under a passed in authorization.
+// Rather than a rich query, this uses the authorization~name index written by
+// InitFileTransfer, so it works on any state database (e.g. LevelDB as well as CouchDB).
+// The result has the same Key/Record shape as the rich queries.
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByAuthorization(ctx TransactionContextInterface, authorization string) ([]queryRecord, error) {
+	records, _, err := getFileTransfersByAuthorization(ctx.GetStub(), ctx.GetCaller().MSPID, authorization, 0, "")
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	return shim.Success(queryResults)
+
+	fmt.Printf("- queryFileTransferByAuthorization found %d transfers\n", len(records))
+	return records, nil
+}
+
+// authorizationNameIndex is the object type of the index entries of the transfers, keyed by authorization~name
+const authorizationNameIndex = "authorization~name"
+
+// =========================================================================================
+// getAuthorizationNameIndexKey returns the key of the authorization~name index entry of a transfer
+// in its collections, or with the name left out, the prefix of the entries of an authorization.
+// The legacy collections keep the composite keys written by earlier versions of the chaincode.
+// The collections of each pair use the composite key without its leading null character, a simple
+// key with the same order, as the shim only starts range queries from simple keys and a page of
+// the index starts from its bookmark
+// =========================================================================================
+func getAuthorizationNameIndexKey(stub shim.ChaincodeStubInterface, collections transferCollections, attributes ...string) (string, error) {
+	indexKey, err := stub.CreateCompositeKey(authorizationNameIndex, attributes)
+	if err != nil || collections.Transfers == legacyCollections.Transfers {
+		return indexKey, err
+	}
+	return indexKey[1:], nil
+}
+
+// =========================================================================================
+// getAuthorizationIndexNames returns the names of the transfers in the authorization~name index of
+// a collection that come after the bookmark, in order. If pageSize is greater than zero, it stops
+// after pageSize + 1 names, as no later name of the collection can be part of the page.
+// The index of the collections of a pair is read from the bookmark on, while the index of the
+// legacy collections, which no longer grows, is read from its start
+// =========================================================================================
+func getAuthorizationIndexNames(stub shim.ChaincodeStubInterface, collections transferCollections, authorization string, pageSize int32, bookmark string) ([]string, error) {
+	legacy := collections.Transfers == legacyCollections.Transfers
+
+	var resultsIterator shim.StateQueryIteratorInterface
+	if legacy {
+		iterator, err := stub.GetPrivateDataByPartialCompositeKey(collections.Transfers, authorizationNameIndex, []string{authorization})
+		if err != nil {
+			return nil, err
+		}
+		resultsIterator = iterator
+	} else {
+		prefix, err := getAuthorizationNameIndexKey(stub, collections, authorization)
+		if err != nil {
+			return nil, err
+		}
+		startKey := prefix
+		if len(bookmark) != 0 {
+			bookmarkKey, err := getAuthorizationNameIndexKey(stub, collections, authorization, bookmark)
+			if err != nil {
+				return nil, err
+			}
+			// no key falls between the key of the bookmark and this one, which is not a key itself
+			startKey = bookmarkKey + "\x00"
+		}
+		iterator, err := stub.GetPrivateDataByRange(collections.Transfers, startKey, prefix+string(utf8.MaxRune))
+		if err != nil {
+			return nil, err
+		}
+		resultsIterator = iterator
+	}
+	defer resultsIterator.Close()
+
+	names := []string{}
+	for resultsIterator.HasNext() {
+		if pageSize > 0 && int32(len(names)) > pageSize {
+			break
+		}
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// get the authorization and name from the authorization~name composite key
+		compositeKey := responseRange.Key
+		if !legacy {
+			compositeKey = "\x00" + compositeKey
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return nil, err
+		}
+		name := compositeKeyParts[1]
+		if len(bookmark) != 0 && name <= bookmark {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// =========================================================================================
+// getFileTransfersByAuthorization reads the transfers sent under an authorization from the
+// authorization~name index of each collection the organization is a member of, ordered by
+// transfer name.
+// If pageSize is greater than zero, at most pageSize transfers with a name after the bookmark are
+// returned, along with the bookmark of the next page, which is empty once there are no more.
+// =========================================================================================
+func getFileTransfersByAuthorization(stub shim.ChaincodeStubInterface, mspID string, authorization string, pageSize int32, bookmark string) ([]queryRecord, string, error) {
+	memberCollections, err := getMemberCollections(stub, mspID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// names are unique across collections, so the names of every collection can be merged in one
+	// order, once the copies of the transfers with recipients in several other organizations are dropped
+	collectionOf := make(map[string]string)
+	names := []string{}
+	for _, collections := range memberCollections {
+		collectionNames, err := getAuthorizationIndexNames(stub, collections, authorization, pageSize, bookmark)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, name := range collectionNames {
+			if _, ok := collectionOf[name]; ok {
+				continue
+			}
+			collectionOf[name] = collections.Transfers
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	records := []queryRecord{}
+	for _, name := range names {
+		if pageSize > 0 && int32(len(records)) == pageSize {
+			// there is at least one more transfer, so the next page starts after the last one returned
+			return records, records[len(records)-1].Key, nil
+		}
+
+		transferAsBytes, err := stub.GetPrivateData(collectionOf[name], name)
+		if err != nil {
+			return nil, "", err
+		} else if transferAsBytes == nil {
+			continue
+		}
+		record, err := newQueryRecord(name, transferAsBytes)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, record)
+	}
+
+	return records, "", nil
 }
 
 // ===== Example: Ad hoc rich query ========================================================
-// queryTransfers uses a query string to perform a query for transfers.
-// Query string matching state database syntax is passed in and executed as is.
+// QueryTransfers uses a query string to perform a query for transfers.
+// Query string matching state database syntax is passed in and checked by validateTransferQuery,
+// which only allows a safe subset of the syntax over fileTransfer fields, before it is executed.
 // Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the queryTransfersForOwner example for parameterized queries.
+// If this is not desired, follow the QueryFileTransferByOriginator example for parameterized queries.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
-func (t *SimpleChaincode) queryTransfers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (c *FileTransferContract) QueryTransfers(ctx TransactionContextInterface, queryString string) ([]queryRecord, error) {
+	query, err := validateTransferQuery(queryString)
+	if err != nil {
+		return nil, err
+	}
+	validatedQueryString, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return getQueryResultForQueryString(ctx.GetStub(), ctx.GetCaller().MSPID, string(validatedQueryString))
+}
 
-	//   0
-	// "queryString"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// queryRecord is one result of a query, with the key and the value of the record.
+// Records returned by an ad hoc query with fields only have the requested fields set
+type queryRecord struct {
+	Key    string        `json:"Key"`
+	Record *fileTransfer `json:"Record"`
+}
+
+// newQueryRecord decodes a transfer returned by a query, filling in the fields of a transfer
+// saved by an earlier version of the chaincode as getFileTransfer does
+func newQueryRecord(key string, value []byte) (queryRecord, error) {
+	var transfer fileTransfer
+	err := json.Unmarshal(value, &transfer)
+	if err != nil {
+		return queryRecord{}, fmt.Errorf("Failed to decode JSON of: %s", string(value))
 	}
+	transfer.normalize()
+	return queryRecord{Key: key, Record: &transfer}, nil
+}
 
-	queryString := args[0]
+// uniqueQueryRecords drops the records of transfers already returned from the collections of
+// another pair, as a transfer with recipients in several other organizations has a copy in each
+func uniqueQueryRecords(records []queryRecord) []queryRecord {
+	returned := make(map[string]bool)
+	unique := []queryRecord{}
+	for _, record := range records {
+		if returned[record.Key] {
+			continue
+		}
+		returned[record.Key] = true
+		unique = append(unique, record)
+	}
+	return unique
+}
 
-	queryResults, err := getQueryResultForQueryString(stub, queryString)
+// =========================================================================================
+// getQueryResultForSelector builds a query string from the passed in selector and executes it.
+// The query string is built by marshaling the selector rather than formatting a string, so
+// the query parameters cannot inject selector clauses.
+// =========================================================================================
+func getQueryResultForSelector(stub shim.ChaincodeStubInterface, mspID string, selector map[string]interface{}) ([]queryRecord, error) {
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
-	return shim.Success(queryResults)
+	return getQueryResultForQueryString(stub, mspID, string(queryString))
 }
 
 // =========================================================================================
-// getQueryResultForQueryString executes the passed in query string.
-// Result set is built and returned as a byte array containing the JSON results.
+// getQueryResultForQueryString executes the passed in query string on each collection the
+// organization is a member of.
+// Result set is built and returned as a list of Key/Record pairs, ordered by key unless the
+// query has a sort, in which case the records of each collection are sorted and come one
+// collection after the other.
 // =========================================================================================
-func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string) ([]byte, error) {
+func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, mspID string, queryString string) ([]queryRecord, error) {
 
 	fmt.Printf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
 
-	resultsIterator, err := stub.GetPrivateDataQueryResult("collectionFileTransfer", queryString)
+	memberCollections, err := getMemberCollections(stub, mspID)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryRecords
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+	records := []queryRecord{}
+	for _, collections := range memberCollections {
+		collectionRecords, err := getCollectionQueryResult(stub, collections.Transfers, queryString)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, collectionRecords...)
+	}
+	records = uniqueQueryRecords(records)
+
+	var query map[string]interface{}
+	if json.Unmarshal([]byte(queryString), &query) == nil && query["sort"] == nil {
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].Key < records[j].Key
+		})
+	}
+
+	fmt.Printf("- getQueryResultForQueryString found %d records\n", len(records))
+
+	return records, nil
+}
+
+// getCollectionQueryResult executes the passed in query string on one collection
+func getCollectionQueryResult(stub shim.ChaincodeStubInterface, collection string, queryString string) ([]queryRecord, error) {
+	resultsIterator, err := stub.GetPrivateDataQueryResult(collection, queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
 
-	bArrayMemberAlreadyWritten := false
+	records := []queryRecord{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		record, err := newQueryRecord(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return nil, err
 		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		records = append(records, record)
 	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
-
-	return buffer.Bytes(), nil
+	return records, nil
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+)
+
+// clientIdentity is the verified identity of the client submitting a transaction.
+// MSPID is the membership service provider of the client's organization and ID is
+// the unique ID of the client certificate within that MSP, as returned by cid.GetID
+type clientIdentity struct {
+	MSPID string
+	ID    string
+}
+
+// ===========================================================================================
+// getClientIdentity reads the submitter's identity from the client identity of the
+// transaction context, which the contract API builds from the signed proposal
+// ===========================================================================================
+func getClientIdentity(identity cid.ClientIdentity) (clientIdentity, error) {
+	if identity == nil {
+		return clientIdentity{}, fmt.Errorf("Failed to read client identity")
+	}
+
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return clientIdentity{}, fmt.Errorf("Failed to read client MSP ID: %s", err.Error())
+	}
+
+	id, err := identity.GetID()
+	if err != nil {
+		return clientIdentity{}, fmt.Errorf("Failed to read client ID: %s", err.Error())
+	}
+
+	return clientIdentity{MSPID: mspID, ID: id}, nil
+}
+
+// isOriginatorOf returns true if the client is the originator of the transfer
+func (c clientIdentity) isOriginatorOf(transfer fileTransfer) bool {
+	return c.MSPID == transfer.OriginatorMSP && c.ID == transfer.Originator
+}
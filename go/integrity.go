@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// fileDigest is the SHA-256 hash and size of a file, as passed in the transfer input
+type fileDigest struct {
+	Hash string `json:"hash"` // hex SHA-256
+	Size int64  `json:"size"` // size in bytes
+}
+
+// fileIntegrityResult is the result of comparing a hash against the ciphertext hash of a transfer
+type fileIntegrityResult struct {
+	Name           string `json:"name"`                                // name of the transfer
+	File           string `json:"file,omitempty" metadata:",optional"` // file of a bundle whose ciphertext hash matches
+	Hash           string `json:"hash"`                                // hash that was checked, normalized to lower case
+	CiphertextHash string `json:"ciphertextHash"`                      // hash registered by the originator
+	Matches        bool   `json:"matches"`
+}
+
+// ===========================================================================================
+// normalizeSHA256 checks that a hash is a hex encoded SHA-256 hash and returns it in lower case,
+// so that hashes compare equal whatever case the client encoded them in
+// ===========================================================================================
+func normalizeSHA256(field string, hash string) (string, error) {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("%s field must be a hex encoded SHA-256 hash", field)
+	}
+	return strings.ToLower(hash), nil
+}
+
+// ===========================================================================================
+// parseFileDigest validates an optional digest of the transfer input. A nil digest is allowed,
+// for clients that do not hash the file, but a digest that is given must have a valid hash
+// ===========================================================================================
+func parseFileDigest(field string, digest *fileDigest) (string, int64, error) {
+	if digest == nil {
+		return "", 0, nil
+	}
+	hash, err := normalizeSHA256(field+".hash", digest.Hash)
+	if err != nil {
+		return "", 0, err
+	}
+	if digest.Size < 0 {
+		return "", 0, fmt.Errorf("%s.size field must not be negative", field)
+	}
+	return hash, digest.Size, nil
+}
+
+// ===========================================================================================
+// VerifyFileIntegrity - compare the SHA-256 hash of a fetched file against the ciphertext hash
+// the originator registered with the transfer, so a recipient can prove the file they fetched
+// from the address is the one that was sent. For a bundle, the hash is compared against the
+// ciphertext hash of each of its files
+// ===========================================================================================
+func (c *FileTransferContract) VerifyFileIntegrity(ctx TransactionContextInterface, name string, hash string) (*fileIntegrityResult, error) {
+	hash, err := normalizeSHA256("hash", hash)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, _, err := getCallerFileTransfer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.isBundle() {
+		return verifyBundleFileIntegrity(transfer, hash)
+	}
+	if len(transfer.CiphertextHash) == 0 {
+		return nil, fmt.Errorf("Transfer %s has no ciphertext hash to verify against", name)
+	}
+
+	return &fileIntegrityResult{
+		Name:           name,
+		Hash:           hash,
+		CiphertextHash: transfer.CiphertextHash,
+		Matches:        hash == transfer.CiphertextHash,
+	}, nil
+}
+
+// verifyBundleFileIntegrity looks for the file of a bundle whose ciphertext hash matches a hash
+func verifyBundleFileIntegrity(transfer fileTransfer, hash string) (*fileIntegrityResult, error) {
+	hashed := false
+	for _, item := range transfer.Items {
+		if item.CiphertextHash == hash {
+			return &fileIntegrityResult{Name: transfer.Name, File: item.Name, Hash: hash, CiphertextHash: item.CiphertextHash, Matches: true}, nil
+		}
+		hashed = hashed || len(item.CiphertextHash) != 0
+	}
+	if !hashed {
+		return nil, fmt.Errorf("Bundle %s has no ciphertext hashes to verify against", transfer.Name)
+	}
+	return &fileIntegrityResult{Name: transfer.Name, Hash: hash, Matches: false}, nil
+}
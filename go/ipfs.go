@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ==== IPFS content identifiers ====
+// A CID (https://github.com/multiformats/cid) is either a version 0 CID, the base58btc encoding
+// of a sha2-256 multihash that always starts with "Qm", or a version 1 CID, a multibase string
+// whose first character names the base, holding the varints <version><codec><multihash>.
+// The chaincode parses them itself rather than depending on go-cid, and only accepts the bases
+// and codecs that IPFS uses for files.
+// ==================================
+
+// ipfsPathPrefix is the prefix of the path form of an address, /ipfs/<cid>/<path>
+const ipfsPathPrefix = "/ipfs/"
+
+// base58Alphabet is the bitcoin base58 alphabet used by base58btc
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// cidCodecs are the multicodec names of the content types a CID may address
+var cidCodecs = map[uint64]string{
+	0x55:   "raw",
+	0x70:   "dag-pb",
+	0x71:   "dag-cbor",
+	0x0129: "dag-json",
+}
+
+// multihashLengths are the digest lengths of the hash functions a CID may use
+var multihashLengths = map[uint64]int{
+	0x12:   32, // sha2-256
+	0x13:   64, // sha2-512
+	0x1e:   32, // blake3
+	0xb220: 32, // blake2b-256
+}
+
+// base32Lower is the RFC 4648 base32 encoding without padding, which multibase uses in lower case
+var base32Lower = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ipfsAddress is an address parsed as an IPFS CID, optionally followed by a path inside it
+type ipfsAddress struct {
+	CID     string `json:"cid"`                                 // the CID, as base58btc for version 0 and lower case base32 for version 1
+	Version int    `json:"cidVersion"`                          // 0 or 1
+	Codec   string `json:"codec"`                               // multicodec name of the content, e.g. dag-pb
+	Path    string `json:"path,omitempty" metadata:",optional"` // path inside the content, starting with a slash
+}
+
+// ===========================================================================================
+// parseIPFSAddress parses an address given as a bare CID or in the /ipfs/<cid>/<path> form,
+// returning the normalized CID along with its codec
+// ===========================================================================================
+func parseIPFSAddress(address string) (*ipfsAddress, error) {
+	cid := address
+	path := ""
+	if strings.HasPrefix(address, ipfsPathPrefix) {
+		cid = strings.TrimPrefix(address, ipfsPathPrefix)
+		if slash := strings.Index(cid, "/"); slash >= 0 {
+			cid, path = cid[:slash], cid[slash:]
+		}
+	}
+	if len(cid) == 0 {
+		return nil, fmt.Errorf("address field must hold an IPFS CID")
+	}
+
+	var parsed *ipfsAddress
+	var err error
+	if len(cid) == 46 && strings.HasPrefix(cid, "Qm") {
+		parsed, err = parseCIDv0(cid)
+	} else {
+		parsed, err = parseCIDv1(cid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("address field is not a valid IPFS CID: %s", err.Error())
+	}
+	parsed.Path = path
+	return parsed, nil
+}
+
+// parseCIDv0 parses a version 0 CID, the base58btc encoding of a sha2-256 multihash of dag-pb content
+func parseCIDv0(cid string) (*ipfsAddress, error) {
+	multihash, err := decodeBase58(cid)
+	if err != nil {
+		return nil, err
+	}
+	if len(multihash) != 34 || multihash[0] != 0x12 || multihash[1] != 0x20 {
+		return nil, fmt.Errorf("version 0 CID must be a sha2-256 multihash")
+	}
+	return &ipfsAddress{CID: cid, Version: 0, Codec: "dag-pb"}, nil
+}
+
+// parseCIDv1 parses a version 1 CID and re-encodes it in lower case base32, the default base of IPFS
+func parseCIDv1(cid string) (*ipfsAddress, error) {
+	data, err := decodeMultibase(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	version, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("truncated version")
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	rest := data[n:]
+
+	codec, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, fmt.Errorf("truncated codec")
+	}
+	codecName, ok := cidCodecs[codec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec 0x%x", codec)
+	}
+
+	err = checkMultihash(rest[n:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipfsAddress{CID: "b" + strings.ToLower(base32Lower.EncodeToString(data)), Version: 1, Codec: codecName}, nil
+}
+
+// checkMultihash checks a multihash, <hash function><digest length><digest>, is complete and
+// that its digest has the length of its hash function
+func checkMultihash(multihash []byte) error {
+	hashFunction, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return fmt.Errorf("truncated multihash")
+	}
+	multihash = multihash[n:]
+
+	length, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return fmt.Errorf("truncated multihash")
+	}
+	digest := multihash[n:]
+
+	wantLength, ok := multihashLengths[hashFunction]
+	if !ok {
+		return fmt.Errorf("unsupported hash function 0x%x", hashFunction)
+	}
+	if length != uint64(wantLength) || len(digest) != wantLength {
+		return fmt.Errorf("digest of hash function 0x%x must be %d bytes", hashFunction, wantLength)
+	}
+	return nil
+}
+
+// decodeMultibase decodes a multibase string, whose first character names its base
+func decodeMultibase(value string) ([]byte, error) {
+	if len(value) < 2 {
+		return nil, fmt.Errorf("too short")
+	}
+	prefix, encoded := value[0], value[1:]
+	switch prefix {
+	case 'b':
+		if encoded != strings.ToLower(encoded) {
+			return nil, fmt.Errorf("base32 must be lower case after the b prefix")
+		}
+		data, err := base32Lower.DecodeString(strings.ToUpper(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base32 encoding")
+		}
+		return data, nil
+	case 'B':
+		data, err := base32Lower.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base32 encoding")
+		}
+		return data, nil
+	case 'z':
+		return decodeBase58(encoded)
+	case 'f', 'F':
+		data, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base16 encoding")
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported multibase prefix %q", prefix)
+	}
+}
+
+// decodeBase58 decodes a base58btc string. Each leading '1' encodes a leading zero byte
+func decodeBase58(value string) ([]byte, error) {
+	number := new(big.Int)
+	radix := big.NewInt(58)
+	leadingZeros := 0
+	for i, char := range value {
+		digit := strings.IndexRune(base58Alphabet, char)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", char)
+		}
+		if digit == 0 && i == leadingZeros {
+			leadingZeros++
+		}
+		number.Mul(number, radix)
+		number.Add(number, big.NewInt(int64(digit)))
+	}
+	return append(make([]byte, leadingZeros), number.Bytes()...), nil
+}
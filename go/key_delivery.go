@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Ways the key material of a transfer may be delivered
+const (
+	keyDeliveryShared   = "shared"   // kept in the private details collection shared by the originator's and the recipients' organizations
+	keyDeliveryImplicit = "implicit" // kept in the implicit collection of each recipient organization, with only its hash in the private details
+)
+
+// transferKeysIndex is the composite key object type of the key material of a transfer in an implicit collection
+const transferKeysIndex = "transferKeys~name"
+
+// transferKeyMaterial is the key material of a transfer delivered to one recipient organization.
+// Only the keys wrapped for the recipients of that organization are included
+type transferKeyMaterial struct {
+	ObjectType    string                `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name          string                `json:"name"`
+	EncryptionKey string                `json:"encryptionKey,omitempty" metadata:",optional"`
+	WrappedKeys   []recipientWrappedKey `json:"wrappedKeys,omitempty" metadata:",optional"`
+	Items         []bundleItemKeys      `json:"items,omitempty" metadata:",optional"` // keys of each file of a bundle, in manifest order
+}
+
+// bundleItemKeys is the key material of one file of a bundle
+type bundleItemKeys struct {
+	Name          string                `json:"name"`
+	EncryptionKey string                `json:"encryptionKey,omitempty" metadata:",optional"`
+	WrappedKeys   []recipientWrappedKey `json:"wrappedKeys,omitempty" metadata:",optional"`
+}
+
+// parseKeyDelivery checks the keyDelivery field of a new transfer, which defaults to shared
+func parseKeyDelivery(keyDelivery string) (string, error) {
+	switch keyDelivery {
+	case "", keyDeliveryShared:
+		return keyDeliveryShared, nil
+	case keyDeliveryImplicit:
+		return keyDeliveryImplicit, nil
+	}
+	return "", fmt.Errorf("keyDelivery field must be %s or %s", keyDeliveryShared, keyDeliveryImplicit)
+}
+
+// getImplicitCollection returns the name of the implicit private data collection of an organization
+func getImplicitCollection(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// filterWrappedKeys returns the keys wrapped for the recipients of an organization
+func filterWrappedKeys(wrappedKeys []recipientWrappedKey, mspID string) []recipientWrappedKey {
+	var filtered []recipientWrappedKey
+	for _, wrapped := range wrappedKeys {
+		if wrapped.RecipientMSP == mspID {
+			filtered = append(filtered, wrapped)
+		}
+	}
+	return filtered
+}
+
+// ===========================================================================================
+// deliverTransferKeys writes the key material of a transfer to the implicit collection of each
+// of its recipient organizations, so that the peers of the originator's organization never hold
+// it unless some of the recipients are in it. The keys are then removed from the private details,
+// which keep the SHA-256 of the key material delivered to each organization instead
+// ===========================================================================================
+func deliverTransferKeys(stub shim.ChaincodeStubInterface, transfer fileTransfer, details *fileTransferPrivateDetails) error {
+	keysKey, err := stub.CreateCompositeKey(transferKeysIndex, []string{transfer.Name})
+	if err != nil {
+		return err
+	}
+
+	keyHashes := make(map[string]string)
+	for _, recipient := range transfer.Recipients {
+		if _, ok := keyHashes[recipient.MSPID]; ok {
+			continue
+		}
+		material := &transferKeyMaterial{
+			ObjectType:    "transferKeyMaterial",
+			Name:          transfer.Name,
+			EncryptionKey: details.EncryptionKey,
+			WrappedKeys:   filterWrappedKeys(details.WrappedKeys, recipient.MSPID),
+		}
+		for _, item := range details.Items {
+			material.Items = append(material.Items, bundleItemKeys{
+				Name:          item.Name,
+				EncryptionKey: item.EncryptionKey,
+				WrappedKeys:   filterWrappedKeys(item.WrappedKeys, recipient.MSPID),
+			})
+		}
+		materialJSONasBytes, err := json.Marshal(material)
+		if err != nil {
+			return err
+		}
+		err = stub.PutPrivateData(getImplicitCollection(recipient.MSPID), keysKey, materialJSONasBytes)
+		if err != nil {
+			return err
+		}
+		materialHash := sha256.Sum256(materialJSONasBytes)
+		keyHashes[recipient.MSPID] = hex.EncodeToString(materialHash[:])
+	}
+
+	details.EncryptionKey = ""
+	details.WrappedKeys = nil
+	for i := range details.Items {
+		details.Items[i].EncryptionKey = ""
+		details.Items[i].WrappedKeys = nil
+	}
+	details.KeyHashes = keyHashes
+	return nil
+}
+
+// ===========================================================================================
+// readTransferKeys reads the key material of a transfer from the implicit collection of the
+// caller's organization, checks it against the hash kept in the private details and puts the
+// keys back into the private details. An organization with no recipients of the transfer was
+// not delivered any keys, so its private details are returned without them
+// ===========================================================================================
+func readTransferKeys(stub shim.ChaincodeStubInterface, caller clientIdentity, details *fileTransferPrivateDetails) error {
+	keyHash, ok := details.KeyHashes[caller.MSPID]
+	if !ok {
+		return nil
+	}
+
+	keysKey, err := stub.CreateCompositeKey(transferKeysIndex, []string{details.Name})
+	if err != nil {
+		return err
+	}
+	materialAsBytes, err := stub.GetPrivateData(getImplicitCollection(caller.MSPID), keysKey)
+	if err != nil {
+		return fmt.Errorf("Failed to get key material for %s: %s", details.Name, err.Error())
+	} else if materialAsBytes == nil {
+		return fmt.Errorf("Key material of transfer %s has not been delivered to %s", details.Name, caller.MSPID)
+	}
+	materialHash := sha256.Sum256(materialAsBytes)
+	if hex.EncodeToString(materialHash[:]) != keyHash {
+		return fmt.Errorf("Key material of transfer %s does not match its hash", details.Name)
+	}
+
+	var material transferKeyMaterial
+	err = json.Unmarshal(materialAsBytes, &material)
+	if err != nil {
+		return fmt.Errorf("Failed to decode JSON of: %s", string(materialAsBytes))
+	}
+	details.EncryptionKey = material.EncryptionKey
+	details.WrappedKeys = material.WrappedKeys
+	for _, itemKeys := range material.Items {
+		for i := range details.Items {
+			if details.Items[i].Name == itemKeys.Name {
+				details.Items[i].EncryptionKey = itemKeys.EncryptionKey
+				details.Items[i].WrappedKeys = itemKeys.WrappedKeys
+			}
+		}
+	}
+	return nil
+}
+
+// ===========================================================================================
+// deleteTransferKeys deletes the key material of a transfer from the implicit collections of the
+// organizations it may have been delivered to, i.e. the members of its collections, as the
+// recipients may have changed since
+// ===========================================================================================
+func deleteTransferKeys(stub shim.ChaincodeStubInterface, collections transferCollections, transfer fileTransfer) error {
+	if transfer.KeyDelivery != keyDeliveryImplicit {
+		return nil
+	}
+	keysKey, err := stub.CreateCompositeKey(transferKeysIndex, []string{transfer.Name})
+	if err != nil {
+		return err
+	}
+	for _, mspID := range collections.organizations() {
+		err = stub.DelPrivateData(getImplicitCollection(mspID), keysKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
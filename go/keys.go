@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// publicKeyIndex is the composite key object type of the public key registry, keyed by mspId~id
+const publicKeyIndex = "publicKey~msp~id"
+
+// Algorithms a client may use to wrap the file encryption key for a recipient
+const (
+	wrapAlgorithmRSAOAEP = "RSA-OAEP-SHA256" // for RSA recipient keys
+	wrapAlgorithmECIES   = "ECIES-SHA256"    // for ECDSA recipient keys
+)
+
+// registeredPublicKey is a public key a client has registered to receive wrapped file keys.
+// The registry is kept in the world state, so that originators in any organization can look up
+// the key of a recipient. Only public keys are ever stored.
+type registeredPublicKey struct {
+	ObjectType   string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	MSPID        string    `json:"mspId"`
+	ID           string    `json:"id"`
+	PublicKey    string    `json:"publicKey"`   // PEM encoded PKIX public key
+	Fingerprint  string    `json:"fingerprint"` // hex SHA-256 of the DER encoded public key
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// wrappedKey is a file encryption key that the originator has encrypted with the recipient's
+// registered public key. The chaincode never sees the plaintext key.
+type wrappedKey struct {
+	Algorithm      string `json:"algorithm"`
+	KeyFingerprint string `json:"keyFingerprint"` // fingerprint of the recipient key used to wrap the file key
+	Ciphertext     string `json:"ciphertext"`     // base64 encoded wrapped file key
+}
+
+// ===========================================================================================
+// parsePublicKey decodes a PEM encoded PKIX public key and returns it with its fingerprint.
+// Only RSA and ECDSA keys are accepted
+// ===========================================================================================
+func parsePublicKey(publicKeyPEM string) (interface{}, string, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, "", fmt.Errorf("publicKey must be a PEM encoded PUBLIC KEY")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to parse public key: %s", err.Error())
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, "", fmt.Errorf("publicKey must be an RSA or ECDSA key")
+	}
+
+	fingerprint := sha256.Sum256(block.Bytes)
+	return publicKey, hex.EncodeToString(fingerprint[:]), nil
+}
+
+// ===========================================================================================
+// getRegisteredPublicKey reads the current public key of a client from the registry
+// ===========================================================================================
+func getRegisteredPublicKey(stub shim.ChaincodeStubInterface, mspID string, id string) (*registeredPublicKey, error) {
+	publicKeyKey, err := stub.CreateCompositeKey(publicKeyIndex, []string{mspID, id})
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyAsBytes, err := stub.GetState(publicKeyKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get public key: %s", err.Error())
+	} else if publicKeyAsBytes == nil {
+		return nil, fmt.Errorf("No public key registered for %s in %s", id, mspID)
+	}
+
+	var publicKey registeredPublicKey
+	err = json.Unmarshal(publicKeyAsBytes, &publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", string(publicKeyAsBytes))
+	}
+	return &publicKey, nil
+}
+
+// ===========================================================================================
+// validateWrappedKey checks that a wrapped file key targets the current registered key of the
+// recipient. The chaincode cannot decrypt the wrapped key, so it checks the fingerprint and,
+// for RSA, that the ciphertext is the size of the recipient's modulus
+// ===========================================================================================
+func validateWrappedKey(stub shim.ChaincodeStubInterface, wrapped *wrappedKey, recipientMSP string, recipient string) error {
+	if len(wrapped.Algorithm) == 0 {
+		return fmt.Errorf("wrappedKey.algorithm field must be a non-empty string")
+	}
+	if len(wrapped.KeyFingerprint) == 0 {
+		return fmt.Errorf("wrappedKey.keyFingerprint field must be a non-empty string")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(wrapped.Ciphertext)
+	if err != nil || len(ciphertext) == 0 {
+		return fmt.Errorf("wrappedKey.ciphertext field must be a non-empty base64 string")
+	}
+
+	recipientKey, err := getRegisteredPublicKey(stub, recipientMSP, recipient)
+	if err != nil {
+		return err
+	}
+	if wrapped.KeyFingerprint != recipientKey.Fingerprint {
+		return fmt.Errorf("wrappedKey was not wrapped for the current key of the recipient, expected fingerprint %s", recipientKey.Fingerprint)
+	}
+
+	publicKey, _, err := parsePublicKey(recipientKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if wrapped.Algorithm != wrapAlgorithmRSAOAEP {
+			return fmt.Errorf("wrappedKey.algorithm must be %s for an RSA recipient key", wrapAlgorithmRSAOAEP)
+		}
+		if len(ciphertext) != key.Size() {
+			return fmt.Errorf("wrappedKey.ciphertext is %d bytes, expected %d for the recipient key", len(ciphertext), key.Size())
+		}
+	case *ecdsa.PublicKey:
+		if wrapped.Algorithm != wrapAlgorithmECIES {
+			return fmt.Errorf("wrappedKey.algorithm must be %s for an ECDSA recipient key", wrapAlgorithmECIES)
+		}
+	}
+	return nil
+}
+
+// ===========================================================================================
+// RegisterPublicKey - register the public key of the calling client, replacing any previous key.
+// Originators wrap file encryption keys for this key, so it becomes the recipient's current key
+// ===========================================================================================
+func (c *FileTransferContract) RegisterPublicKey(ctx TransactionContextInterface, publicKeyPEM string) (*registeredPublicKey, error) {
+	fmt.Println("- start registerPublicKey")
+
+	stub := ctx.GetStub()
+
+	_, fingerprint, err := parsePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	caller := ctx.GetCaller()
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := &registeredPublicKey{
+		ObjectType:   "publicKey",
+		MSPID:        caller.MSPID,
+		ID:           caller.ID,
+		PublicKey:    publicKeyPEM,
+		Fingerprint:  fingerprint,
+		RegisteredAt: txTime,
+	}
+	publicKeyJSONasBytes, err := json.Marshal(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyKey, err := stub.CreateCompositeKey(publicKeyIndex, []string{caller.MSPID, caller.ID})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(publicKeyKey, publicKeyJSONasBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end registerPublicKey (success)")
+	return publicKey, nil
+}
+
+// ===========================================================================================
+// GetPublicKey - read the current registered public key of a client
+// ===========================================================================================
+func (c *FileTransferContract) GetPublicKey(ctx TransactionContextInterface, mspID string, id string) (*registeredPublicKey, error) {
+	return getRegisteredPublicKey(ctx.GetStub(), mspID, id)
+}
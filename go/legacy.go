@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// =======Legacy function names ===============================================================
+// Before the contract model, the chaincode was invoked with lowercase function names and
+// positional string arguments, e.g. {"Args":["readFileTransfer","transfer1"]}. The contract API
+// calls the transaction named like the function with its first letter uppercased, so most legacy
+// names reach their typed transaction directly, with the same JSON responses as before. The
+// contract API ignores extra arguments and reports missing ones in its own words, so legacyChaincode
+// checks the number of positional arguments of a legacy name first. The few names that are not a
+// transaction once capitalized are routed to UnknownTransaction, which calls their transaction.
+// ============================================================================================
+
+// legacyFunction is a function that was callable before the contract model
+type legacyFunction struct {
+	args      int                                                                                                // number of positional arguments
+	argsError string                                                                                             // message returned when a different number of arguments is passed
+	call      func(c *FileTransferContract, ctx TransactionContextInterface, args []string) (interface{}, error) // nil if the contract API calls the typed transaction
+}
+
+var legacyFunctions = map[string]legacyFunction{
+	"init": {0, "Incorrect number of arguments. Expecting 0",
+		func(c *FileTransferContract, ctx TransactionContextInterface, args []string) (interface{}, error) {
+			return nil, nil
+		}},
+	"delete": {0, "Incorrect number of arguments. Private transfer name must be passed in transient map.",
+		func(c *FileTransferContract, ctx TransactionContextInterface, args []string) (interface{}, error) {
+			return nil, c.DeleteFileTransfer(ctx)
+		}},
+	"initFileTransfer":                               {0, "Incorrect number of arguments. Private transfer data must be passed in transient map.", nil},
+	"readFileTransfer":                               {1, "Incorrect number of arguments. Expecting name of the transfer to query", nil},
+	"readFileTransferPrivateDetails":                 {1, "Incorrect number of arguments. Expecting name of the transfer to query", nil},
+	"updateFileTransfer":                             {0, "Incorrect number of arguments. Private transfer data must be passed in transient map.", nil},
+	"getTransferHistory":                             {1, "Incorrect number of arguments. Expecting name of the transfer to query", nil},
+	"supersedeFileTransfer":                          {0, "Incorrect number of arguments. Private transfer data must be passed in transient map.", nil},
+	"createAuthorization":                            {0, "Incorrect number of arguments. Authorization must be passed in transient map.", nil},
+	"getAuthorization":                               {1, "Incorrect number of arguments. Expecting id of the authorization to query", nil},
+	"getTransfersByAuthorization":                    {1, "Incorrect number of arguments. Expecting id of the authorization", nil},
+	"getBundleAccess":                                {1, "Incorrect number of arguments. Expecting name of the bundle to query", nil},
+	"getTransferLineage":                             {1, "Incorrect number of arguments. Expecting name of the transfer to query", nil},
+	"revokeFileTransfer":                             {0, "Incorrect number of arguments. Private transfer name must be passed in transient map.", nil},
+	"revokeAuthorization":                            {0, "Incorrect number of arguments. Authorization id must be passed in transient map.", nil},
+	"revokeAuthorizationCascade":                     {0, "Incorrect number of arguments. Authorization id must be passed in transient map.", nil},
+	"purgeExpiredTransfers":                          {0, "Incorrect number of arguments. Expecting 0", nil},
+	"registerPublicKey":                              {1, "Incorrect number of arguments. Expecting PEM encoded public key", nil},
+	"getPublicKey":                                   {2, "Incorrect number of arguments. Expecting MSP ID and client ID", nil},
+	"queryFileTransferByOriginator":                  {2, "Incorrect number of arguments. Expecting MSP ID and client ID of the originator", nil},
+	"queryFileTransferByRecipient":                   {2, "Incorrect number of arguments. Expecting MSP ID and client ID of the recipient", nil},
+	"queryFileTransferByStatus":                      {1, "Incorrect number of arguments. Expecting status", nil},
+	"queryFileTransferByAuthorization":               {1, "Incorrect number of arguments. Expecting authorization", nil},
+	"queryTransfers":                                 {1, "Incorrect number of arguments. Expecting 1", nil},
+	"queryFileTransferByOriginatorWithPagination":    {4, "Incorrect number of arguments. Expecting MSP ID and client ID of the originator, page size and bookmark", nil},
+	"queryFileTransferByRecipientWithPagination":     {4, "Incorrect number of arguments. Expecting MSP ID and client ID of the recipient, page size and bookmark", nil},
+	"queryFileTransferByStatusWithPagination":        {3, "Incorrect number of arguments. Expecting status, page size and bookmark", nil},
+	"queryFileTransferByAuthorizationWithPagination": {3, "Incorrect number of arguments. Expecting authorization, page size and bookmark", nil},
+	"queryTransfersWithPagination":                   {3, "Incorrect number of arguments. Expecting query string, page size and bookmark", nil},
+	"confirmDelivery":                                {0, "Incorrect number of arguments. Private transfer name must be passed in transient map.", nil},
+	"accessFile":                                     {0, "Incorrect number of arguments. Private transfer data must be passed in transient map.", nil},
+	"getAccessLog":                                   {1, "Incorrect number of arguments. Expecting name of the transfer to query", nil},
+	"acknowledgeReceipt":                             {0, "Incorrect number of arguments. Receipt must be passed in transient map.", nil},
+	"getReceipt":                                     {3, "Incorrect number of arguments. Expecting name of the transfer, MSP ID and client ID of the recipient", nil},
+	"verifyFileIntegrity":                            {2, "Incorrect number of arguments. Expecting name of the transfer and hex SHA-256 of the file", nil},
+}
+
+// legacyChaincode is the contract chaincode, with the number of arguments of legacy function names checked
+type legacyChaincode struct {
+	*contractapi.ContractChaincode
+}
+
+// newFileTransferChaincode returns the chaincode of the file transfer contract
+func newFileTransferChaincode() (*legacyChaincode, error) {
+	chaincode, err := contractapi.NewChaincode(newFileTransferContract())
+	if err != nil {
+		return nil, err
+	}
+	return &legacyChaincode{chaincode}, nil
+}
+
+// Invoke checks the number of positional arguments of a legacy function name, and the page size of a
+// legacy paginated query, which the contract API would otherwise reject in its own words, before the
+// contract API handles the invocation
+func (cc *legacyChaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	legacy, ok := legacyFunctions[function]
+	if !ok {
+		return cc.ContractChaincode.Invoke(stub)
+	}
+	if len(args) != legacy.args {
+		return shim.Error(legacy.argsError)
+	}
+	if strings.HasSuffix(function, "WithPagination") {
+		// the page size is the argument before the bookmark
+		_, err := parsePageSize(args[len(args)-2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return cc.ContractChaincode.Invoke(stub)
+}
+
+// Start starts the chaincode, which ContractChaincode.Start would do without the legacy checks
+func (cc *legacyChaincode) Start() error {
+	return shim.Start(cc)
+}
+
+// ===========================================================================================
+// invokeLegacyFunction is the UnknownTransaction of the contract. It calls the transaction
+// behind a legacy function name with the positional arguments of the invocation, and returns
+// the result as JSON
+// ===========================================================================================
+func (c *FileTransferContract) invokeLegacyFunction(ctx TransactionContextInterface) (string, error) {
+	function, args := ctx.GetStub().GetFunctionAndParameters()
+
+	legacy, ok := legacyFunctions[function]
+	if !ok || legacy.call == nil {
+		return "", fmt.Errorf("Received unknown function invocation: %s", function)
+	}
+	if len(args) != legacy.args {
+		return "", errors.New(legacy.argsError)
+	}
+
+	result, err := legacy.call(c, ctx, args)
+	if err != nil || result == nil {
+		return "", err
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultAsBytes), nil
+}
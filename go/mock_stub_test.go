@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// privateDataMockStub extends shimtest.MockStub with the parts of the stub the chaincode relies on
+// that MockStub lacks or only partly supports: world state partial composite key queries, private
+// data collections with deletes, range, partial composite key and (simple) rich queries, transient maps, the creator, the transaction
+// timestamp and chaincode events. Composite key handling is inherited from shimtest.MockStub.
+type privateDataMockStub struct {
+	*shimtest.MockStub
+
+	cc          shim.Chaincode
+	args        [][]byte
+	txID        string
+	txTimestamp *timestamp.Timestamp
+	creator     []byte
+	transient   map[string][]byte
+	state       map[string][]byte
+	pvtState    map[string]map[string][]byte
+	events      []*pb.ChaincodeEvent
+	txCount     int
+}
+
+func newPrivateDataMockStub(cc shim.Chaincode) *privateDataMockStub {
+	return &privateDataMockStub{
+		MockStub: shimtest.NewMockStub("fileTransfer", cc),
+		cc:       cc,
+		state:    make(map[string][]byte),
+		pvtState: make(map[string]map[string][]byte),
+	}
+}
+
+// mockInvoke invokes the chaincode as the passed in identity, with the passed in transient map,
+// at the passed in transaction time
+func (stub *privateDataMockStub) mockInvoke(identity testIdentity, txTime time.Time, transient map[string][]byte, args ...string) pb.Response {
+	stub.txCount++
+	stub.txID = fmt.Sprintf("tx%04d", stub.txCount)
+	stub.txTimestamp, _ = ptypes.TimestampProto(txTime)
+	stub.creator = identity.creator
+	stub.transient = transient
+	stub.args = make([][]byte, len(args))
+	for i, arg := range args {
+		stub.args[i] = []byte(arg)
+	}
+	stub.events = nil
+
+	return stub.cc.Invoke(stub)
+}
+
+func (stub *privateDataMockStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *privateDataMockStub) GetStringArgs() []string {
+	args := make([]string, len(stub.args))
+	for i, arg := range stub.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+func (stub *privateDataMockStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (stub *privateDataMockStub) GetTxID() string {
+	return stub.txID
+}
+
+func (stub *privateDataMockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return stub.txTimestamp, nil
+}
+
+func (stub *privateDataMockStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+func (stub *privateDataMockStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+func (stub *privateDataMockStub) SetEvent(name string, payload []byte) error {
+	if len(name) == 0 {
+		return errors.New("event name can not be empty string")
+	}
+	stub.events = append(stub.events, &pb.ChaincodeEvent{EventName: name, Payload: payload})
+	return nil
+}
+
+func (stub *privateDataMockStub) GetState(key string) ([]byte, error) {
+	return stub.state[key], nil
+}
+
+func (stub *privateDataMockStub) PutState(key string, value []byte) error {
+	stub.state[key] = value
+	return nil
+}
+
+func (stub *privateDataMockStub) DelState(key string) error {
+	delete(stub.state, key)
+	return nil
+}
+
+func (stub *privateDataMockStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	var stateKeys []string
+	for key := range stub.state {
+		if strings.HasPrefix(key, prefix) {
+			stateKeys = append(stateKeys, key)
+		}
+	}
+	sort.Strings(stateKeys)
+	var results []*queryresult.KV
+	for _, key := range stateKeys {
+		results = append(results, &queryresult.KV{Key: key, Value: stub.state[key]})
+	}
+	return &mockQueryIterator{results: results}, nil
+}
+
+func (stub *privateDataMockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	return stub.pvtState[collection][key], nil
+}
+
+// GetPrivateDataHash returns the SHA-256 of a value, which every peer of the channel holds, whether
+// or not its organization is a member of the collection
+func (stub *privateDataMockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value, ok := stub.pvtState[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (stub *privateDataMockStub) PutPrivateData(collection string, key string, value []byte) error {
+	if _, ok := stub.pvtState[collection]; !ok {
+		stub.pvtState[collection] = make(map[string][]byte)
+	}
+	stub.pvtState[collection][key] = value
+	return nil
+}
+
+func (stub *privateDataMockStub) DelPrivateData(collection string, key string) error {
+	delete(stub.pvtState[collection], key)
+	return nil
+}
+
+// GetPrivateDataByRange returns the keys of a collection in [startKey, endKey). As on a peer, the keys
+// may not be composite keys, an empty startKey starts after the composite keys, and an empty endKey
+// has no upper limit
+func (stub *privateDataMockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	for _, key := range []string{startKey, endKey} {
+		if strings.HasPrefix(key, "\x00") {
+			return nil, fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	if startKey == "" {
+		startKey = "\x01"
+	}
+	var results []*queryresult.KV
+	for _, key := range stub.sortedKeys(collection) {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			results = append(results, &queryresult.KV{Key: key, Value: stub.pvtState[collection][key]})
+		}
+	}
+	return &mockQueryIterator{results: results}, nil
+}
+
+func (stub *privateDataMockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	var results []*queryresult.KV
+	for _, key := range stub.sortedKeys(collection) {
+		if strings.HasPrefix(key, prefix) {
+			results = append(results, &queryresult.KV{Key: key, Value: stub.pvtState[collection][key]})
+		}
+	}
+	return &mockQueryIterator{results: results}, nil
+}
+
+// GetPrivateDataQueryResult runs a CouchDB query with a small selector engine that supports the
+// comparison operators, $in, $nin, $exists, $elemMatch, $and, $or, $nor, $not and limit.
+// Results are always in key order
+func (stub *privateDataMockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	var parsed map[string]interface{}
+	err := json.Unmarshal([]byte(query), &parsed)
+	if err != nil {
+		return nil, err
+	}
+	selector, _ := parsed["selector"].(map[string]interface{})
+	limit := -1
+	if value, ok := parsed["limit"].(float64); ok {
+		limit = int(value)
+	}
+
+	var results []*queryresult.KV
+	for _, key := range stub.sortedKeys(collection) {
+		if strings.HasPrefix(key, "\x00") {
+			continue
+		}
+		var doc map[string]interface{}
+		if json.Unmarshal(stub.pvtState[collection][key], &doc) != nil {
+			continue
+		}
+		doc["_id"] = key
+		if matchesSelector(doc, selector) {
+			results = append(results, &queryresult.KV{Key: key, Value: stub.pvtState[collection][key]})
+		}
+		if limit >= 0 && len(results) == limit {
+			break
+		}
+	}
+	return &mockQueryIterator{results: results}, nil
+}
+
+func (stub *privateDataMockStub) sortedKeys(collection string) []string {
+	var keys []string
+	for key := range stub.pvtState[collection] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func matchesSelector(doc map[string]interface{}, selector map[string]interface{}) bool {
+	for key, condition := range selector {
+		switch key {
+		case "$and", "$or", "$nor":
+			matched := 0
+			clauses, _ := condition.([]interface{})
+			for _, clause := range clauses {
+				clauseSelector, _ := clause.(map[string]interface{})
+				if matchesSelector(doc, clauseSelector) {
+					matched++
+				}
+			}
+			if (key == "$and" && matched != len(clauses)) || (key == "$or" && matched == 0) || (key == "$nor" && matched != 0) {
+				return false
+			}
+		case "$not":
+			clauseSelector, _ := condition.(map[string]interface{})
+			if matchesSelector(doc, clauseSelector) {
+				return false
+			}
+		default:
+			value, exists := doc[key]
+			if !matchesCondition(value, exists, condition) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesCondition(value interface{}, exists bool, condition interface{}) bool {
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		return exists && compareValues(value, condition) == 0
+	}
+	for operator, operand := range operators {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = exists && compareValues(value, operand) == 0
+		case "$ne":
+			matched = !exists || compareValues(value, operand) != 0
+		case "$gt":
+			matched = exists && compareValues(value, operand) > 0
+		case "$gte":
+			matched = exists && compareValues(value, operand) >= 0
+		case "$lt":
+			matched = exists && compareValues(value, operand) < 0
+		case "$lte":
+			matched = exists && compareValues(value, operand) <= 0
+		case "$in", "$nin":
+			operands, _ := operand.([]interface{})
+			for _, candidate := range operands {
+				if exists && compareValues(value, candidate) == 0 {
+					matched = true
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			matched = exists == operand
+		case "$elemMatch":
+			elements, _ := value.([]interface{})
+			elementSelector, _ := operand.(map[string]interface{})
+			for _, element := range elements {
+				elementDoc, ok := element.(map[string]interface{})
+				if ok && matchesSelector(elementDoc, elementSelector) {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues compares two JSON scalars, returning 2 if they can't be compared
+func compareValues(a interface{}, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a == b {
+			return 0
+		}
+	case nil:
+		if b == nil {
+			return 0
+		}
+	}
+	return 2
+}
+
+// mockQueryIterator iterates over a fixed list of query results
+type mockQueryIterator struct {
+	results []*queryresult.KV
+	index   int
+}
+
+func (iter *mockQueryIterator) HasNext() bool {
+	return iter.index < len(iter.results)
+}
+
+func (iter *mockQueryIterator) Next() (*queryresult.KV, error) {
+	if !iter.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	iter.index++
+	return iter.results[iter.index-1], nil
+}
+
+func (iter *mockQueryIterator) Close() error {
+	return nil
+}
+
+// testIdentity is a client identity with a self-signed certificate, serialized as a proposal creator
+type testIdentity struct {
+	creator []byte
+	mspID   string
+	id      string
+}
+
+// creatorStub lets the client identity library read the ID of a creator
+type creatorStub []byte
+
+func (creator creatorStub) GetCreator() ([]byte, error) {
+	return creator, nil
+}
+
+func newTestIdentity(t *testing.T, mspID string, commonName string) testIdentity {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := cid.GetID(creatorStub(creator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testIdentity{creator: creator, mspID: mspID, id: id}
+}
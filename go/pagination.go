@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// =======Paginated queries ===================================================================
+// The private data query API has no native pagination, so pagination is emulated with a
+// deterministic key-ordered cursor. Results are always ordered by key, and the bookmark is the
+// key of the last record of a page; the next page holds the records with a key after it.
+// An empty bookmark requests the first page, and an empty bookmark is returned with the last page.
+// ============================================================================================
+
+// maxPageSize is the largest page a client may request
+const maxPageSize = 1000
+
+// paginatedQueryResult is one page of the results of a query
+type paginatedQueryResult struct {
+	Records      []queryRecord `json:"records"`
+	FetchedCount int           `json:"fetchedCount"`
+	Bookmark     string        `json:"bookmark"`
+}
+
+// validatePageSize checks the page size requested by a paginated query
+func validatePageSize(pageSize int32) error {
+	if pageSize <= 0 || pageSize > maxPageSize {
+		return fmt.Errorf("pageSize must be a number between 1 and %d", maxPageSize)
+	}
+	return nil
+}
+
+// parsePageSize parses the page size argument of a paginated query called by its legacy name
+func parsePageSize(arg string) (int32, error) {
+	pageSize, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("pageSize must be a number between 1 and %d", maxPageSize)
+	}
+	return int32(pageSize), validatePageSize(int32(pageSize))
+}
+
+// ===== Example: Paginated ad hoc rich query ==============================================
+// QueryTransfersWithPagination uses a query string to perform a query for transfers, one page at a time.
+// The query is checked by validateTransferQuery, and may not have a sort as the results are always ordered by key.
+// The limit of the query, at most maxQueryLimit, caps the size of each page.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryTransfersWithPagination(ctx TransactionContextInterface, queryString string, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	err := validatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := validateTransferQuery(queryString)
+	if err != nil {
+		return nil, err
+	}
+	if limit := query["limit"].(int); int(pageSize) > limit {
+		pageSize = int32(limit)
+	}
+
+	return getQueryResultForQueryWithPagination(ctx.GetStub(), ctx.GetCaller().MSPID, query, pageSize, bookmark)
+}
+
+// ===== Paginated parameterized rich query ================================================
+// QueryFileTransferByOriginatorWithPagination queries for transfers sent by the passed in client, one page at a time.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByOriginatorWithPagination(ctx TransactionContextInterface, originatorMSP string, originator string, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	err := validatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return getQueryResultForSelectorWithPagination(ctx.GetStub(), ctx.GetCaller().MSPID, originatorSelector(originatorMSP, originator), pageSize, bookmark)
+}
+
+// ===== Paginated parameterized rich query ================================================
+// QueryFileTransferByRecipientWithPagination queries for transfers sent to the passed in client, one page at a time.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByRecipientWithPagination(ctx TransactionContextInterface, recipientMSP string, recipient string, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	err := validatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return getQueryResultForSelectorWithPagination(ctx.GetStub(), ctx.GetCaller().MSPID, recipientSelector(recipientMSP, recipient), pageSize, bookmark)
+}
+
+// ===== Paginated parameterized rich query ================================================
+// QueryFileTransferByStatusWithPagination queries for transfers with the passed in status, one page at a time.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByStatusWithPagination(ctx TransactionContextInterface, status string, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	transferStatus, err := parseTransferStatus(status)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return getQueryResultForSelectorWithPagination(ctx.GetStub(), ctx.GetCaller().MSPID, statusSelector(transferStatus), pageSize, bookmark)
+}
+
+// ===== Paginated composite key query =====================================================
+// QueryFileTransferByAuthorizationWithPagination queries for transfers sent under the passed in
+// authorization, one page at a time, using the authorization~name index.
+// Works on any state database (e.g. LevelDB as well as CouchDB)
+// =========================================================================================
+func (c *FileTransferContract) QueryFileTransferByAuthorizationWithPagination(ctx TransactionContextInterface, authorization string, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	err := validatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	records, nextBookmark, err := getFileTransfersByAuthorization(ctx.GetStub(), ctx.GetCaller().MSPID, authorization, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	return &paginatedQueryResult{
+		Records:      records,
+		FetchedCount: len(records),
+		Bookmark:     nextBookmark,
+	}, nil
+}
+
+// =========================================================================================
+// getQueryResultForSelectorWithPagination builds a query from the passed in selector and
+// returns one page of its results.
+// =========================================================================================
+func getQueryResultForSelectorWithPagination(stub shim.ChaincodeStubInterface, mspID string, selector map[string]interface{}, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	return getQueryResultForQueryWithPagination(stub, mspID, map[string]interface{}{"selector": selector}, pageSize, bookmark)
+}
+
+// =========================================================================================
+// getQueryResultForQueryWithPagination returns one page of the results of the passed in query.
+// The selector of the query is restricted to keys after the bookmark and the results are sorted
+// by key, so that reading pageSize records gives a stable page whichever peer executes it.
+// The query is executed on each collection the organization is a member of, and as transfer
+// names are unique across collections, the first pageSize records of the merged results, without
+// the copies of the same transfer found in the collections of several pairs, are the page.
+// =========================================================================================
+func getQueryResultForQueryWithPagination(stub shim.ChaincodeStubInterface, mspID string, query map[string]interface{}, pageSize int32, bookmark string) (*paginatedQueryResult, error) {
+	selector, ok := query["selector"]
+	if !ok {
+		return nil, fmt.Errorf("query must have a selector")
+	}
+	if _, ok := query["sort"]; ok {
+		return nil, fmt.Errorf("sort is not supported by paginated queries, the results are ordered by key")
+	}
+	if _, ok := query["skip"]; ok {
+		return nil, fmt.Errorf("skip is not supported by paginated queries, use the bookmark instead")
+	}
+
+	if len(bookmark) != 0 {
+		query["selector"] = map[string]interface{}{
+			"$and": []interface{}{
+				selector,
+				map[string]interface{}{"_id": map[string]interface{}{"$gt": bookmark}},
+			},
+		}
+	}
+	query["sort"] = []interface{}{map[string]interface{}{"_id": "asc"}}
+	// one more than the page size, to tell whether there is a next page
+	query["limit"] = pageSize + 1
+
+	queryString, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("- getQueryResultForQueryWithPagination queryString:\n%s\n", string(queryString))
+
+	memberCollections, err := getMemberCollections(stub, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []queryRecord{}
+	for _, collections := range memberCollections {
+		collectionRecords, err := getCollectionQueryResult(stub, collections.Transfers, string(queryString))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, collectionRecords...)
+	}
+	records = uniqueQueryRecords(records)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+
+	result := &paginatedQueryResult{Records: records}
+	if int32(len(records)) > pageSize {
+		// there is at least one more record, so the next page starts after the last one returned
+		result.Records = records[:pageSize]
+		result.Bookmark = result.Records[len(result.Records)-1].Key
+	}
+	result.FetchedCount = len(result.Records)
+
+	return result, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// maxQueryLimit caps the number of records an ad hoc query may return
+const maxQueryLimit = 100
+
+// queryableTransferFields are the fields of fileTransfer that an ad hoc query may select, sort or return
+var queryableTransferFields = map[string]bool{
+	"docType":            true,
+	"name":               true,
+	"description":        true,
+	"originator":         true,
+	"originatorMSP":      true,
+	"authorization":      true,
+	"hasBeenAccessed":    true,
+	"recipients":         true,
+	"notBefore":          true,
+	"expiresAt":          true,
+	"revision":           true,
+	"version":            true,
+	"previousName":       true,
+	"supersededBy":       true,
+	"ciphertextHash":     true,
+	"ciphertextSize":     true,
+	"items":              true,
+	"keyDelivery":        true,
+	"status":             true,
+	"statusChangedBy":    true,
+	"statusChangedByMSP": true,
+	"statusChangedAt":    true,
+	"revocationReason":   true,
+	"revokedBy":          true,
+	"revokedByMSP":       true,
+	"revokedAt":          true,
+}
+
+// queryableRecipientFields are the fields of a transferRecipient that may be used inside $elemMatch on recipients
+var queryableRecipientFields = map[string]bool{
+	"id":              true,
+	"mspId":           true,
+	"hasBeenAccessed": true,
+	"accessCount":     true,
+	"firstAccessedAt": true,
+	"lastAccessedAt":  true,
+	"acknowledgedAt":  true,
+}
+
+// Operators that may be applied to a field. $regex and $where are deliberately left out
+var queryFieldOperators = map[string]bool{
+	"$eq":     true,
+	"$ne":     true,
+	"$gt":     true,
+	"$gte":    true,
+	"$lt":     true,
+	"$lte":    true,
+	"$in":     true,
+	"$nin":    true,
+	"$exists": true,
+}
+
+// ===========================================================================================
+// validateTransferQuery parses an ad hoc CouchDB query and checks it only uses a safe subset of
+// the query syntax: a selector over whitelisted fileTransfer fields with a fixed set of operators,
+// optionally with fields, sort, limit and use_index. The limit is capped at maxQueryLimit and the
+// selector is forced to only match fileTransfer documents.
+// Returns the query to execute.
+// ===========================================================================================
+func validateTransferQuery(queryString string) (map[string]interface{}, error) {
+	var query map[string]interface{}
+	err := json.Unmarshal([]byte(queryString), &query)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", queryString)
+	}
+
+	for key := range query {
+		switch key {
+		case "selector", "fields", "sort", "limit", "use_index":
+		default:
+			return nil, fmt.Errorf("query may not contain %s", key)
+		}
+	}
+
+	selector, ok := query["selector"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("query must have a selector object")
+	}
+	err = validateSelector(selector, queryableTransferFields)
+	if err != nil {
+		return nil, err
+	}
+	selector["docType"] = "fileTransfer"
+
+	if fields, ok := query["fields"]; ok {
+		err = validateQueryFields(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sort, ok := query["sort"]; ok {
+		err = validateQuerySort(sort)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limit := maxQueryLimit
+	if value, ok := query["limit"]; ok {
+		requested, ok := value.(float64)
+		if !ok || requested != float64(int(requested)) || requested <= 0 {
+			return nil, fmt.Errorf("limit must be a positive integer")
+		}
+		if int(requested) < limit {
+			limit = int(requested)
+		}
+	}
+	query["limit"] = limit
+
+	if useIndex, ok := query["use_index"]; ok {
+		err = validateQueryUseIndex(useIndex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return query, nil
+}
+
+// validateSelector checks every field and operator of a selector
+func validateSelector(selector map[string]interface{}, allowedFields map[string]bool) error {
+	for key, value := range selector {
+		switch key {
+		case "$and", "$or", "$nor":
+			clauses, ok := value.([]interface{})
+			if !ok || len(clauses) == 0 {
+				return fmt.Errorf("%s must be a non-empty array of selectors", key)
+			}
+			for _, clause := range clauses {
+				clauseSelector, ok := clause.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("%s must be a non-empty array of selectors", key)
+				}
+				err := validateSelector(clauseSelector, allowedFields)
+				if err != nil {
+					return err
+				}
+			}
+		case "$not":
+			clauseSelector, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("$not must be a selector")
+			}
+			err := validateSelector(clauseSelector, allowedFields)
+			if err != nil {
+				return err
+			}
+		default:
+			if !allowedFields[key] {
+				return fmt.Errorf("selector may not use field %s", key)
+			}
+			err := validateFieldCondition(key, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateFieldCondition checks the condition on a single field, which is either a value to match
+// or an object of operators
+func validateFieldCondition(field string, condition interface{}) error {
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		return validateQueryValue(field, condition)
+	}
+
+	for operator, operand := range operators {
+		switch {
+		case operator == "$elemMatch" && field == "recipients":
+			recipientSelector, ok := operand.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("$elemMatch on recipients must be a selector")
+			}
+			err := validateSelector(recipientSelector, queryableRecipientFields)
+			if err != nil {
+				return err
+			}
+		case operator == "$in" || operator == "$nin":
+			values, ok := operand.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s on %s must be an array", operator, field)
+			}
+			for _, value := range values {
+				err := validateQueryValue(field, value)
+				if err != nil {
+					return err
+				}
+			}
+		case operator == "$exists":
+			if _, ok := operand.(bool); !ok {
+				return fmt.Errorf("$exists on %s must be a boolean", field)
+			}
+		case queryFieldOperators[operator]:
+			err := validateQueryValue(field, operand)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("selector may not use operator %s on %s", operator, field)
+		}
+	}
+	return nil
+}
+
+// validateQueryValue checks that a value compared against a field is a plain JSON scalar
+func validateQueryValue(field string, value interface{}) error {
+	switch value.(type) {
+	case string, float64, bool, nil:
+		return nil
+	default:
+		return fmt.Errorf("value of %s must be a string, number, boolean or null", field)
+	}
+}
+
+// validateQueryFields checks the fields a query returns are whitelisted
+func validateQueryFields(fields interface{}) error {
+	fieldList, ok := fields.([]interface{})
+	if !ok {
+		return fmt.Errorf("fields must be an array of field names")
+	}
+	for _, field := range fieldList {
+		name, ok := field.(string)
+		if !ok || !queryableTransferFields[name] {
+			return fmt.Errorf("fields may not contain %v", field)
+		}
+	}
+	return nil
+}
+
+// validateQuerySort checks a query only sorts on whitelisted fields
+func validateQuerySort(sort interface{}) error {
+	sortList, ok := sort.([]interface{})
+	if !ok {
+		return fmt.Errorf("sort must be an array")
+	}
+	for _, entry := range sortList {
+		switch value := entry.(type) {
+		case string:
+			if !queryableTransferFields[value] {
+				return fmt.Errorf("sort may not use field %s", value)
+			}
+		case map[string]interface{}:
+			for field, direction := range value {
+				if !queryableTransferFields[field] {
+					return fmt.Errorf("sort may not use field %s", field)
+				}
+				if direction != "asc" && direction != "desc" {
+					return fmt.Errorf("sort direction of %s must be asc or desc", field)
+				}
+			}
+		default:
+			return fmt.Errorf("sort must be an array of field names or {field: direction} objects")
+		}
+	}
+	return nil
+}
+
+// validateQueryUseIndex checks use_index is a design document name, or a design document and index name
+func validateQueryUseIndex(useIndex interface{}) error {
+	switch value := useIndex.(type) {
+	case string:
+		return nil
+	case []interface{}:
+		if len(value) == 2 {
+			_, designDocOK := value[0].(string)
+			_, indexOK := value[1].(string)
+			if designDocOK && indexOK {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("use_index must be a design document name or a [design document, index name] array")
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// receiptIndex is the composite key object type of the receipts, keyed by transfer~msp~id so
+// that each recipient has at most one receipt per transfer
+const receiptIndex = "receipt~transfer~msp~id"
+
+// maxReceiptClockSkew is how far the time signed into a receipt may be from the transaction time
+const maxReceiptClockSkew = 10 * time.Minute
+
+// transferReceipt is a recipient's signed confirmation that they received and decrypted the file
+// of a transfer. The signature is over receiptMessage(name, contentHash, timestamp), made with
+// the private key matching the recipient's registered public key
+type transferReceipt struct {
+	ObjectType     string    `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name           string    `json:"name"`    // name of the transfer
+	Recipient      string    `json:"recipient"`
+	RecipientMSP   string    `json:"recipientMSP"`
+	ContentHash    string    `json:"contentHash"`    // hex SHA-256 of the decrypted file
+	Timestamp      string    `json:"timestamp"`      // RFC 3339 time signed by the recipient, exactly as signed
+	Signature      string    `json:"signature"`      // base64 signature over the receipt message
+	KeyFingerprint string    `json:"keyFingerprint"` // fingerprint of the registered key that verified the signature
+	TxID           string    `json:"txId"`
+	RecordedAt     time.Time `json:"recordedAt"` // transaction timestamp of the acknowledgment
+}
+
+// ===========================================================================================
+// receiptMessage returns the bytes a recipient signs to acknowledge a transfer: the transfer name,
+// the hex SHA-256 of the decrypted content and the RFC 3339 timestamp, separated by newlines
+// ===========================================================================================
+func receiptMessage(name string, contentHash string, timestamp string) []byte {
+	return []byte(name + "\n" + contentHash + "\n" + timestamp)
+}
+
+// ===========================================================================================
+// verifyReceiptSignature checks a signature over a receipt message with a registered public key.
+// ECDSA signatures are ASN.1 DER encoded and RSA signatures are PKCS #1 v1.5, both over SHA-256
+// ===========================================================================================
+func verifyReceiptSignature(publicKey interface{}, message []byte, signature []byte) error {
+	digest := sha256.Sum256(message)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return fmt.Errorf("signature does not verify with the registered key of the recipient")
+		}
+	case *rsa.PublicKey:
+		err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			return fmt.Errorf("signature does not verify with the registered key of the recipient")
+		}
+	default:
+		return fmt.Errorf("registered key of the recipient cannot verify signatures")
+	}
+	return nil
+}
+
+// getReceiptKey returns the key of the receipt of a recipient for a transfer
+func getReceiptKey(stub shim.ChaincodeStubInterface, name string, recipientMSP string, recipient string) (string, error) {
+	return stub.CreateCompositeKey(receiptIndex, []string{name, recipientMSP, recipient})
+}
+
+// ===========================================================================================
+// AcknowledgeReceipt - record a recipient's signed confirmation that they received and decrypted
+// the file of a transfer. The receipt is passed in the transient map under the transfer_receipt key.
+// The signature is verified with the recipient's registered public key before the receipt is
+// stored. Once every recipient has acknowledged, the transfer moves to Acknowledged
+// ===========================================================================================
+func (c *FileTransferContract) AcknowledgeReceipt(ctx TransactionContextInterface) error {
+	fmt.Println("- start acknowledgeReceipt")
+
+	type receiptTransientInput struct {
+		Name        string `json:"name"`
+		ContentHash string `json:"contentHash"` // hex SHA-256 of the decrypted file
+		Timestamp   string `json:"timestamp"`   // RFC 3339
+		Signature   string `json:"signature"`   // base64
+	}
+
+	stub := ctx.GetStub()
+
+	var receiptInput receiptTransientInput
+	err := getTransientInput(ctx, "transfer_receipt", &receiptInput)
+	if err != nil {
+		return err
+	}
+
+	if len(receiptInput.Name) == 0 {
+		return fmt.Errorf("name field must be a non-empty string")
+	}
+	contentHash, err := hex.DecodeString(receiptInput.ContentHash)
+	if err != nil || len(contentHash) != sha256.Size {
+		return fmt.Errorf("contentHash field must be a hex encoded SHA-256 hash")
+	}
+	signedAt, err := time.Parse(time.RFC3339, receiptInput.Timestamp)
+	if err != nil {
+		return fmt.Errorf("timestamp field must be an RFC 3339 time")
+	}
+	signature, err := base64.StdEncoding.DecodeString(receiptInput.Signature)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("signature field must be a non-empty base64 string")
+	}
+
+	transfer, collections, err := getCallerFileTransfer(ctx, receiptInput.Name)
+	if err != nil {
+		return err
+	}
+
+	// only a recipient who has accessed the file may acknowledge it, and only once
+	caller := ctx.GetCaller()
+	recipientIndex := transfer.findRecipient(caller)
+	if recipientIndex < 0 {
+		return fmt.Errorf("Only the recipients of the transfer may acknowledge it: %s", receiptInput.Name)
+	}
+	recipient := &transfer.Recipients[recipientIndex]
+	if !recipient.HasBeenAccessed {
+		return fmt.Errorf("The file must be accessed before it is acknowledged: %s", receiptInput.Name)
+	}
+	if !recipient.AcknowledgedAt.IsZero() {
+		return fmt.Errorf("Receipt of transfer %s has already been acknowledged", receiptInput.Name)
+	}
+	if !canTransition(transfer.Status, statusAcknowledged) {
+		return fmt.Errorf("Transfer %s is %s and cannot be acknowledged", transfer.Name, transfer.Status)
+	}
+
+	// the signed time must be close to the transaction time, and after the recipient got the file
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	if signedAt.Before(txTime.Add(-maxReceiptClockSkew)) || signedAt.After(txTime.Add(maxReceiptClockSkew)) {
+		return fmt.Errorf("timestamp must be within %s of the transaction time", maxReceiptClockSkew)
+	}
+	// a transfer accessed before accesses were tracked per recipient has no first access time to check against
+	if !recipient.FirstAccessedAt.IsZero() && signedAt.Before(recipient.FirstAccessedAt.Add(-maxReceiptClockSkew)) {
+		return fmt.Errorf("timestamp must not be before the file was accessed")
+	}
+
+	registeredKey, err := getRegisteredPublicKey(stub, caller.MSPID, caller.ID)
+	if err != nil {
+		return err
+	}
+	publicKey, _, err := parsePublicKey(registeredKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = verifyReceiptSignature(publicKey, receiptMessage(receiptInput.Name, receiptInput.ContentHash, receiptInput.Timestamp), signature)
+	if err != nil {
+		return err
+	}
+
+	receipt := &transferReceipt{
+		ObjectType:     "receipt",
+		Name:           receiptInput.Name,
+		Recipient:      caller.ID,
+		RecipientMSP:   caller.MSPID,
+		ContentHash:    receiptInput.ContentHash,
+		Timestamp:      receiptInput.Timestamp,
+		Signature:      receiptInput.Signature,
+		KeyFingerprint: registeredKey.Fingerprint,
+		TxID:           stub.GetTxID(),
+		RecordedAt:     txTime,
+	}
+	receiptJSONasBytes, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	receiptKey, err := getReceiptKey(stub, receipt.Name, receipt.RecipientMSP, receipt.Recipient)
+	if err != nil {
+		return err
+	}
+	err = collections.putTransfers(stub, receiptKey, receiptJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	// the transfer is acknowledged once all of its recipients have acknowledged it
+	recipient.AcknowledgedAt = txTime
+	if transfer.allRecipientsAcknowledged() {
+		err = transitionFileTransfer(stub, &transfer, statusAcknowledged, caller)
+		if err != nil {
+			return err
+		}
+	}
+
+	transferJSONasBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	err = collections.putTransfers(stub, transfer.Name, transferJSONasBytes) //rewrite the transfer
+	if err != nil {
+		return err
+	}
+
+	err = emitTransferEvent(stub, eventReceiptAcknowledged, transfer.Name, caller)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("- end acknowledgeReceipt (success)")
+	return nil
+}
+
+// ===========================================================================================
+// GetReceipt - read the receipt of a recipient for a transfer. Only the originator of the
+// transfer and the recipient who signed the receipt may read it
+// ===========================================================================================
+func (c *FileTransferContract) GetReceipt(ctx TransactionContextInterface, name string, recipientMSP string, recipient string) (*transferReceipt, error) {
+	stub := ctx.GetStub()
+
+	transfer, collections, err := getCallerFileTransfer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	caller := ctx.GetCaller()
+	isSigner := caller.MSPID == recipientMSP && caller.ID == recipient
+	if !caller.isOriginatorOf(transfer) && !isSigner {
+		return nil, fmt.Errorf("Only the originator of the transfer may read its receipts: %s", name)
+	}
+
+	receiptKey, err := getReceiptKey(stub, name, recipientMSP, recipient)
+	if err != nil {
+		return nil, err
+	}
+	receiptAsBytes, err := stub.GetPrivateData(collections.Transfers, receiptKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get receipt: %s", err.Error())
+	} else if receiptAsBytes == nil {
+		return nil, fmt.Errorf("Receipt does not exist for transfer %s", name)
+	}
+
+	var receipt transferReceipt
+	err = json.Unmarshal(receiptAsBytes, &receipt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", string(receiptAsBytes))
+	}
+	return &receipt, nil
+}
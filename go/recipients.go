@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// transferRecipient is one of the recipients of a transfer, with their own access tracking
+type transferRecipient struct {
+	ID              string    `json:"id"`    // client ID of the recipient
+	MSPID           string    `json:"mspId"` // MSP ID of the recipient
+	HasBeenAccessed bool      `json:"hasBeenAccessed"`
+	AccessCount     int       `json:"accessCount"`
+	FirstAccessedAt time.Time `json:"firstAccessedAt"` // zero until the recipient accesses the file
+	LastAccessedAt  time.Time `json:"lastAccessedAt"`
+	AcknowledgedAt  time.Time `json:"acknowledgedAt"` // set when the recipient signs a receipt
+}
+
+// recipientWrappedKey is the file encryption key wrapped for the registered public key of one recipient
+type recipientWrappedKey struct {
+	Recipient    string     `json:"recipient"`
+	RecipientMSP string     `json:"recipientMSP"`
+	WrappedKey   wrappedKey `json:"wrappedKey"`
+}
+
+// recipientTransientInput is a recipient of a new transfer, as passed in the transient map
+type recipientTransientInput struct {
+	Recipient    string      `json:"recipient"`
+	RecipientMSP string      `json:"recipientMSP"`
+	WrappedKey   *wrappedKey `json:"wrappedKey"` // required for every recipient unless encryptionKey is given
+}
+
+// ===========================================================================================
+// normalizeRecipientInputs returns the recipients of a transfer input, which are given either as a
+// recipients list or, for a single recipient, by the recipient, recipientMSP and wrappedKey fields
+// ===========================================================================================
+func normalizeRecipientInputs(inputs []recipientTransientInput, recipient string, recipientMSP string, wrapped *wrappedKey) ([]recipientTransientInput, error) {
+	if len(inputs) != 0 {
+		if len(recipient) != 0 || len(recipientMSP) != 0 || wrapped != nil {
+			return nil, fmt.Errorf("recipient, recipientMSP and wrappedKey fields must be empty when recipients is given")
+		}
+		return inputs, nil
+	}
+	if len(recipient) == 0 {
+		return nil, fmt.Errorf("recipient field must be a non-empty string")
+	}
+	if len(recipientMSP) == 0 {
+		return nil, fmt.Errorf("recipientMSP field must be a non-empty string")
+	}
+	return []recipientTransientInput{{Recipient: recipient, RecipientMSP: recipientMSP, WrappedKey: wrapped}}, nil
+}
+
+// ===========================================================================================
+// parseRecipients validates the recipients of a new transfer and returns them along with the
+// wrapped key entry of each recipient. Either a single plaintext encryptionKey is shared by all
+// recipients, or every recipient must have a file key wrapped for their registered public key
+// ===========================================================================================
+func parseRecipients(stub shim.ChaincodeStubInterface, inputs []recipientTransientInput, encryptionKey string) ([]transferRecipient, []recipientWrappedKey, error) {
+	recipients, err := parseRecipientIdentities(inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrappedKeys := []recipientWrappedKey{}
+	for i, input := range inputs {
+		if input.WrappedKey != nil {
+			// the plaintext key must never reach the ledger when the key is wrapped
+			if len(encryptionKey) != 0 {
+				return nil, nil, fmt.Errorf("encryptionKey field must be empty when wrappedKey is given")
+			}
+			err := validateWrappedKey(stub, input.WrappedKey, input.RecipientMSP, input.Recipient)
+			if err != nil {
+				return nil, nil, fmt.Errorf("recipient %d: %s", i, err.Error())
+			}
+			wrappedKeys = append(wrappedKeys, recipientWrappedKey{
+				Recipient:    input.Recipient,
+				RecipientMSP: input.RecipientMSP,
+				WrappedKey:   *input.WrappedKey,
+			})
+		} else if len(encryptionKey) == 0 {
+			return nil, nil, fmt.Errorf("recipient %d must have a wrappedKey when no encryptionKey is given", i)
+		}
+	}
+
+	return recipients, wrappedKeys, nil
+}
+
+// ===========================================================================================
+// parseRecipientIdentities validates the MSP ID and client ID of each recipient of a new transfer,
+// without looking at their keys, and returns the recipients
+// ===========================================================================================
+func parseRecipientIdentities(inputs []recipientTransientInput) ([]transferRecipient, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("recipients field must contain at least one recipient")
+	}
+
+	recipients := make([]transferRecipient, 0, len(inputs))
+	seen := make(map[string]bool)
+	for i, input := range inputs {
+		if len(input.Recipient) == 0 {
+			return nil, fmt.Errorf("recipient field of recipient %d must be a non-empty string", i)
+		}
+		if len(input.RecipientMSP) == 0 {
+			return nil, fmt.Errorf("recipientMSP field of recipient %d must be a non-empty string", i)
+		}
+		if seen[input.RecipientMSP+"::"+input.Recipient] {
+			return nil, fmt.Errorf("recipient %d is listed more than once", i)
+		}
+		seen[input.RecipientMSP+"::"+input.Recipient] = true
+
+		recipients = append(recipients, transferRecipient{ID: input.Recipient, MSPID: input.RecipientMSP})
+	}
+	return recipients, nil
+}
+
+// findRecipient returns the index of the client in the recipients of the transfer, or -1 if it is not a recipient
+func (transfer fileTransfer) findRecipient(client clientIdentity) int {
+	for i, recipient := range transfer.Recipients {
+		if recipient.MSPID == client.MSPID && recipient.ID == client.ID {
+			return i
+		}
+	}
+	return -1
+}
+
+// markRecipientAccessed records an access of the file by one of the recipients of the transfer
+func (transfer *fileTransfer) markRecipientAccessed(index int, accessedAt time.Time) {
+	recipient := &transfer.Recipients[index]
+	if !recipient.HasBeenAccessed {
+		recipient.HasBeenAccessed = true
+		recipient.FirstAccessedAt = accessedAt
+	}
+	recipient.AccessCount++
+	recipient.LastAccessedAt = accessedAt
+}
+
+// allRecipientsAcknowledged returns true once every recipient of the transfer has acknowledged receipt
+func (transfer fileTransfer) allRecipientsAcknowledged() bool {
+	for _, recipient := range transfer.Recipients {
+		if recipient.AcknowledgedAt.IsZero() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// ==================================================
+// RevokeFileTransfer - withdraw a file without deleting the transfer.
+// The private details (address and encryption key) are purged, but the transfer
+// record is kept, marked as revoked, as evidence that the transfer existed.
+// The name and reason are passed in the transient map under the transfer_revoke key
+// ==================================================
+func (c *FileTransferContract) RevokeFileTransfer(ctx TransactionContextInterface) error {
+	fmt.Println("- start revoke transfer")
+
+	type transferRevokeTransientInput struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+
+	stub := ctx.GetStub()
+
+	var transferRevokeInput transferRevokeTransientInput
+	err := getTransientInput(ctx, "transfer_revoke", &transferRevokeInput)
+	if err != nil {
+		return err
+	}
+
+	if len(transferRevokeInput.Name) == 0 {
+		return fmt.Errorf("name field must be a non-empty string")
+	}
+	if len(transferRevokeInput.Reason) == 0 {
+		return fmt.Errorf("reason field must be a non-empty string")
+	}
+
+	transferToRevoke, collections, err := getCallerFileTransfer(ctx, transferRevokeInput.Name)
+	if err != nil {
+		return err
+	}
+
+	// only the originator of the transfer may revoke it
+	caller := ctx.GetCaller()
+	if !caller.isOriginatorOf(transferToRevoke) {
+		return fmt.Errorf("Only the originator of the transfer may revoke it: %s", transferRevokeInput.Name)
+	}
+
+	err = revokeTransfer(stub, collections, &transferToRevoke, transferRevokeInput.Reason, caller)
+	if err != nil {
+		return err
+	}
+
+	err = emitTransferEvent(stub, eventTransferRevoked, transferToRevoke.Name, caller)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("- end revoke transfer (success)")
+	return nil
+}
+
+// ===========================================================================================
+// revokeTransfer marks a transfer as revoked by the actor, saves it in its collections, and purges
+// its private details so that the file can no longer be located or decrypted
+// ===========================================================================================
+func revokeTransfer(stub shim.ChaincodeStubInterface, collections transferCollections, transfer *fileTransfer, reason string, actor clientIdentity) error {
+	err := transitionFileTransfer(stub, transfer, statusRevoked, actor)
+	if err != nil {
+		return err
+	}
+	transfer.RevocationReason = reason
+	transfer.RevokedBy = actor.ID
+	transfer.RevokedByMSP = actor.MSPID
+	revokedAt := transfer.StatusChangedAt
+	transfer.RevokedAt = revokedAt
+
+	transferJSONasBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	err = collections.putTransfers(stub, transfer.Name, transferJSONasBytes) //rewrite the transfer
+	if err != nil {
+		return err
+	}
+
+	// purge the address and encryption key so the file can no longer be located or decrypted, along
+	// with the previous file locations kept by its history
+	err = collections.delPrivateDetails(stub, transfer.Name)
+	if err != nil {
+		return err
+	}
+	err = collections.deletePrivateDetailsByPartialCompositeKey(stub, revisionIndex, []string{transfer.Name})
+	if err != nil {
+		return err
+	}
+	return deleteTransferKeys(stub, collections, *transfer)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// transferStatus is the lifecycle status of a fileTransfer
+type transferStatus string
+
+const (
+	statusNew          transferStatus = ""             // not yet saved to state
+	statusCreated      transferStatus = "Created"      // saved by the originator
+	statusDelivered    transferStatus = "Delivered"    // delivery of the file to the recipient has been confirmed
+	statusAccessed     transferStatus = "Accessed"     // the recipient has accessed the file at least once
+	statusAcknowledged transferStatus = "Acknowledged" // the recipient has acknowledged receipt of the file
+	statusSuperseded   transferStatus = "Superseded"   // a newer version of the document has been sent
+	statusRevoked      transferStatus = "Revoked"      // the originator has withdrawn the file
+	statusExpired      transferStatus = "Expired"      // the file is no longer retrievable
+	statusDeleted      transferStatus = "Deleted"      // the transfer has been removed from state
+)
+
+// validTransitions lists, for each status, the statuses a transfer may move to.
+// Delivered may move to itself so that each recipient can confirm the delivery of the file.
+// Accessed may move to itself so that the recipient can access the file more than once, while an
+// Acknowledged transfer keeps its status when its file is accessed again, see AccessFile.
+// Deleted is terminal.
+var validTransitions = map[transferStatus][]transferStatus{
+	statusNew:          {statusCreated},
+	statusCreated:      {statusDelivered, statusAccessed, statusSuperseded, statusRevoked, statusExpired, statusDeleted},
+	statusDelivered:    {statusDelivered, statusAccessed, statusSuperseded, statusRevoked, statusExpired, statusDeleted},
+	statusAccessed:     {statusAccessed, statusAcknowledged, statusSuperseded, statusRevoked, statusExpired, statusDeleted},
+	statusAcknowledged: {statusSuperseded, statusRevoked, statusExpired, statusDeleted},
+	statusSuperseded:   {statusRevoked, statusExpired, statusDeleted},
+	statusRevoked:      {statusDeleted},
+	statusExpired:      {statusDeleted},
+	statusDeleted:      {},
+}
+
+// canTransition returns true if the transition table allows a transfer to move from one status to another
+func canTransition(from transferStatus, to transferStatus) bool {
+	for _, allowed := range validTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTransferStatus returns the status with the passed in name, or an error if there is no such status
+func parseTransferStatus(name string) (transferStatus, error) {
+	status := transferStatus(name)
+	if _, ok := validTransitions[status]; !ok || status == statusNew {
+		return statusNew, fmt.Errorf("Unknown status: %s", name)
+	}
+	return status, nil
+}
+
+// ===========================================================================================
+// transitionFileTransfer moves a transfer to a new status, recording who moved it and when.
+// Every handler that changes the status of a transfer must go through this function so that
+// invalid moves, such as accessing a revoked transfer, are rejected. The caller is responsible
+// for saving the transfer to state afterwards.
+// ===========================================================================================
+func transitionFileTransfer(stub shim.ChaincodeStubInterface, transfer *fileTransfer, to transferStatus, actor clientIdentity) error {
+	from := transfer.Status
+	if !canTransition(from, to) {
+		if from == statusNew {
+			return fmt.Errorf("Transfer %s has not been created and cannot be moved to %s", transfer.Name, to)
+		}
+		return fmt.Errorf("Transfer %s is %s and cannot be moved to %s", transfer.Name, from, to)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = to
+	transfer.StatusChangedBy = actor.ID
+	transfer.StatusChangedByMSP = actor.MSPID
+	transfer.StatusChangedAt = txTime
+	if to == statusAccessed {
+		transfer.HasBeenAccessed = true
+	}
+
+	fmt.Printf("- transfer %s moved from %s to %s\n", transfer.Name, from, to)
+	return nil
+}
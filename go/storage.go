@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Storage schemes a file may be located by
+const (
+	storageSchemeIPFS  = "ipfs"  // a CID in an IPFS file system
+	storageSchemeS3    = "s3"    // an object in an S3 compatible bucket
+	storageSchemeHTTPS = "https" // a URL served over https
+	storageSchemeFile  = "file"  // a path on a shared file system, such as an SFTP share
+)
+
+// storageLocatorFields are the fields of a storageLocator that each scheme may use
+var storageLocatorFields = map[string]map[string]bool{
+	storageSchemeIPFS:  {"cid": true, "codec": true, "path": true},
+	storageSchemeS3:    {"bucket": true, "key": true, "region": true, "version": true, "endpoint": true},
+	storageSchemeHTTPS: {"url": true},
+	storageSchemeFile:  {"host": true, "path": true},
+}
+
+var (
+	// s3BucketPattern matches the names S3 allows for buckets
+	s3BucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	// s3RegionPattern matches region names such as eu-west-1 or us-gov-east-1
+	s3RegionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	// hostPattern matches host names, optionally followed by a port
+	hostPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]{1,5})?$`)
+)
+
+// storageLocator locates the encrypted file of a transfer. Only the fields of its scheme are set
+type storageLocator struct {
+	Scheme string `json:"scheme"` // ipfs, s3, https or file
+
+	CID   string `json:"cid,omitempty" metadata:",optional"`   // ipfs: the CID, as base58btc for version 0 and lower case base32 for version 1
+	Codec string `json:"codec,omitempty" metadata:",optional"` // ipfs: multicodec name of the content, set by the chaincode
+
+	Bucket   string `json:"bucket,omitempty" metadata:",optional"`   // s3
+	Key      string `json:"key,omitempty" metadata:",optional"`      // s3: key of the object in the bucket
+	Region   string `json:"region,omitempty" metadata:",optional"`   // s3, optional
+	Version  string `json:"version,omitempty" metadata:",optional"`  // s3, optional: version ID of the object
+	Endpoint string `json:"endpoint,omitempty" metadata:",optional"` // s3, optional: https URL of an S3 compatible service other than AWS
+
+	URL string `json:"url,omitempty" metadata:",optional"` // https
+
+	Host string `json:"host,omitempty" metadata:",optional"` // file, optional: host serving the file system
+	Path string `json:"path,omitempty" metadata:",optional"` // file: absolute path of the file; ipfs, optional: path inside the CID
+}
+
+// ===========================================================================================
+// newIPFSLocator returns the locator of an address given as a bare CID or in the /ipfs/<cid>/<path>
+// form, which is how files were located before other schemes were supported
+// ===========================================================================================
+func newIPFSLocator(address string) (*storageLocator, error) {
+	ipfs, err := parseIPFSAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &storageLocator{Scheme: storageSchemeIPFS, CID: ipfs.CID, Codec: ipfs.Codec, Path: ipfs.Path}, nil
+}
+
+// ===========================================================================================
+// parseTransferLocation returns the locator of the file of a transfer input, given either as an
+// IPFS address or as a typed location, along with the address to store in the private details
+// ===========================================================================================
+func parseTransferLocation(address string, location *storageLocator) (*storageLocator, string, error) {
+	if location != nil {
+		if len(address) != 0 {
+			return nil, "", fmt.Errorf("address field must be empty when location is given")
+		}
+		locator, err := validateStorageLocator(*location)
+		if err != nil {
+			return nil, "", err
+		}
+		return locator, locator.address(), nil
+	}
+
+	if len(address) == 0 {
+		return nil, "", fmt.Errorf("address field must be a non-empty string")
+	}
+	locator, err := newIPFSLocator(address)
+	if err != nil {
+		return nil, "", err
+	}
+	return locator, address, nil
+}
+
+// ===========================================================================================
+// legacyStorageLocator returns the locator of a transfer saved with only a plain address, which
+// is read as ipfs. An address that is not a valid CID is returned as is in the cid field
+// ===========================================================================================
+func legacyStorageLocator(address string) *storageLocator {
+	locator, err := newIPFSLocator(address)
+	if err != nil {
+		return &storageLocator{Scheme: storageSchemeIPFS, CID: address}
+	}
+	return locator
+}
+
+// ===========================================================================================
+// validateStorageLocator checks a locator of the transfer input against the rules of its scheme
+// and returns it normalized, e.g. with the CID of an ipfs locator in its canonical form
+// ===========================================================================================
+func validateStorageLocator(locator storageLocator) (*storageLocator, error) {
+	allowed, ok := storageLocatorFields[locator.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("location.scheme field must be one of ipfs, s3, https or file")
+	}
+	for field, value := range locator.fields() {
+		if len(value) != 0 && !allowed[field] {
+			return nil, fmt.Errorf("location.%s field must be empty for %s locations", field, locator.Scheme)
+		}
+	}
+
+	switch locator.Scheme {
+	case storageSchemeIPFS:
+		if len(locator.Codec) != 0 {
+			return nil, fmt.Errorf("location.codec field is set by the chaincode and must be empty")
+		}
+		if len(locator.CID) == 0 || strings.Contains(locator.CID, "/") {
+			return nil, fmt.Errorf("location.cid field must be a non-empty CID")
+		}
+		if len(locator.Path) != 0 && !strings.HasPrefix(locator.Path, "/") {
+			return nil, fmt.Errorf("location.path field must start with a slash")
+		}
+		return newIPFSLocator(ipfsPathPrefix + locator.CID + locator.Path)
+
+	case storageSchemeS3:
+		if !s3BucketPattern.MatchString(locator.Bucket) || strings.Contains(locator.Bucket, "..") {
+			return nil, fmt.Errorf("location.bucket field must be a valid S3 bucket name")
+		}
+		if len(locator.Key) == 0 || len(locator.Key) > 1024 {
+			return nil, fmt.Errorf("location.key field must be a non-empty string of at most 1024 bytes")
+		}
+		if len(locator.Region) != 0 && !s3RegionPattern.MatchString(locator.Region) {
+			return nil, fmt.Errorf("location.region field must be a region name such as eu-west-1")
+		}
+		if len(locator.Endpoint) != 0 {
+			err := checkHTTPSURL("location.endpoint", locator.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case storageSchemeHTTPS:
+		err := checkHTTPSURL("location.url", locator.URL)
+		if err != nil {
+			return nil, err
+		}
+
+	case storageSchemeFile:
+		if len(locator.Host) != 0 && !hostPattern.MatchString(locator.Host) {
+			return nil, fmt.Errorf("location.host field must be a host name")
+		}
+		if !strings.HasPrefix(locator.Path, "/") {
+			return nil, fmt.Errorf("location.path field must be an absolute path")
+		}
+		for _, segment := range strings.Split(locator.Path, "/") {
+			if segment == ".." {
+				return nil, fmt.Errorf("location.path field must not contain .. segments")
+			}
+		}
+	}
+	return &locator, nil
+}
+
+// checkHTTPSURL checks a field holds an absolute https URL without credentials
+func checkHTTPSURL(field string, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "https" || len(parsed.Host) == 0 {
+		return fmt.Errorf("%s field must be an https URL", field)
+	}
+	if parsed.User != nil {
+		return fmt.Errorf("%s field must not contain credentials", field)
+	}
+	return nil
+}
+
+// fields returns the scheme specific fields of the locator by their JSON names
+func (locator storageLocator) fields() map[string]string {
+	return map[string]string{
+		"cid":      locator.CID,
+		"codec":    locator.Codec,
+		"bucket":   locator.Bucket,
+		"key":      locator.Key,
+		"region":   locator.Region,
+		"version":  locator.Version,
+		"endpoint": locator.Endpoint,
+		"url":      locator.URL,
+		"host":     locator.Host,
+		"path":     locator.Path,
+	}
+}
+
+// ===========================================================================================
+// address returns the locator as a single string, which is stored as the address of the private
+// details so that clients that only know about addresses still get something meaningful
+// ===========================================================================================
+func (locator storageLocator) address() string {
+	switch locator.Scheme {
+	case storageSchemeS3:
+		return "s3://" + locator.Bucket + "/" + locator.Key
+	case storageSchemeHTTPS:
+		return locator.URL
+	case storageSchemeFile:
+		return "file://" + locator.Host + locator.Path
+	default:
+		if len(locator.Path) != 0 {
+			return ipfsPathPrefix + locator.CID + locator.Path
+		}
+		return locator.CID
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// ===========================================================================================
+// SupersedeFileTransfer - send a new version of a document as a new transfer linked to the
+// transfer it replaces. The new transfer is passed in the transient map under the fileTransfer
+// key, as for initFileTransfer, with the name of the previous transfer in previousName. The new
+// transfer gets the next version, and the previous one is marked Superseded so that its file can
+// no longer be accessed. The new version may be sent to recipients of another organization, in
+// which case it is kept in the collections of that pair of organizations
+// ===========================================================================================
+func (c *FileTransferContract) SupersedeFileTransfer(ctx TransactionContextInterface) error {
+	fmt.Println("- start supersede transfer")
+
+	stub := ctx.GetStub()
+
+	var transferInput transferTransientInput
+	err := getTransientInput(ctx, "fileTransfer", &transferInput)
+	if err != nil {
+		return err
+	}
+
+	if len(transferInput.PreviousName) == 0 {
+		return fmt.Errorf("previousName field must be a non-empty string")
+	}
+
+	previous, previousCollections, err := getCallerFileTransfer(ctx, transferInput.PreviousName)
+	if err != nil {
+		return err
+	}
+
+	// only the originator of a transfer may send a new version of it
+	caller := ctx.GetCaller()
+	if !caller.isOriginatorOf(previous) {
+		return fmt.Errorf("Only the originator of the transfer may supersede it: %s", previous.Name)
+	}
+	if len(previous.SupersededBy) != 0 {
+		return fmt.Errorf("Transfer %s has already been superseded by %s", previous.Name, previous.SupersededBy)
+	}
+	if !canTransition(previous.Status, statusSuperseded) {
+		return fmt.Errorf("Transfer %s is %s and cannot be superseded", previous.Name, previous.Status)
+	}
+
+	transfer, err := createFileTransfer(ctx, transferInput, &previous)
+	if err != nil {
+		return err
+	}
+
+	err = transitionFileTransfer(stub, &previous, statusSuperseded, caller)
+	if err != nil {
+		return err
+	}
+	previous.SupersededBy = transfer.Name
+
+	previousJSONasBytes, err := json.Marshal(previous)
+	if err != nil {
+		return err
+	}
+	err = previousCollections.putTransfers(stub, previous.Name, previousJSONasBytes) //rewrite the previous transfer
+	if err != nil {
+		return err
+	}
+
+	err = setTransferEvent(stub, &transferEvent{Type: eventTransferSuperseded, Name: transfer.Name, Previous: previous.Name}, caller)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("- end supersede transfer (success)")
+	return nil
+}
+
+// ===========================================================================================
+// GetTransferLineage returns every version of the document sent by a transfer, from the newest
+// to the oldest. The transfer may be any version of the document. Versions kept in collections the
+// caller's organization is not a member of end the lineage, as they can't be read
+// ===========================================================================================
+func (c *FileTransferContract) GetTransferLineage(ctx TransactionContextInterface, name string) ([]fileTransfer, error) {
+	stub := ctx.GetStub()
+	caller := ctx.GetCaller()
+
+	transfer, _, err := getCallerFileTransfer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// follow the chain forward to the newest version, then walk back to the oldest one.
+	// A version that has been deleted ends the chain
+	visited := map[string]bool{transfer.Name: true}
+	for len(transfer.SupersededBy) != 0 && !visited[transfer.SupersededBy] {
+		next, found, err := getLinkedFileTransfer(stub, caller, transfer.SupersededBy)
+		if err != nil {
+			return nil, err
+		} else if !found {
+			break
+		}
+		transfer = next
+		visited[transfer.Name] = true
+	}
+
+	lineage := []fileTransfer{transfer}
+	visited = map[string]bool{transfer.Name: true}
+	for len(transfer.PreviousName) != 0 && !visited[transfer.PreviousName] {
+		previous, found, err := getLinkedFileTransfer(stub, caller, transfer.PreviousName)
+		if err != nil {
+			return nil, err
+		} else if !found {
+			break
+		}
+		transfer = previous
+		visited[transfer.Name] = true
+		lineage = append(lineage, transfer)
+	}
+
+	fmt.Printf("- getTransferLineage found %d versions of %s\n", len(lineage), name)
+	return lineage, nil
+}
+
+// getLinkedFileTransfer reads a transfer linked to another one, which may have been deleted since
+// or be kept in collections the caller's organization is not a member of
+func getLinkedFileTransfer(stub shim.ChaincodeStubInterface, caller clientIdentity, name string) (fileTransfer, bool, error) {
+	collections, _, err := lookupTransferCollections(stub, name)
+	if err != nil {
+		return fileTransfer{}, false, err
+	}
+	collections, ok := collections.resolve(caller.MSPID)
+	if !ok {
+		return fileTransfer{}, false, nil
+	}
+	transferAsBytes, err := stub.GetPrivateData(collections.Transfers, name)
+	if err != nil {
+		return fileTransfer{}, false, fmt.Errorf("Failed to get transfer: %s", err.Error())
+	} else if transferAsBytes == nil {
+		return fileTransfer{}, false, nil
+	}
+	transfer, err := getFileTransfer(stub, collections, name)
+	return transfer, err == nil, err
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// revisionIndex is the composite key object type of the history entries of a transfer, keyed by
+// transfer~revision. The revision is zero padded so that the entries sort in revision order
+const revisionIndex = "transfer~revision"
+
+// fileTransferRevision is a history entry, written each time a transfer is updated. Previous holds
+// the values of the changed fields before the update. The previous location of the file is kept
+// in the private details collection, see fileTransferPrivateRevision
+type fileTransferRevision struct {
+	ObjectType   string                `json:"docType"`  //docType is used to distinguish the various types of objects in state database
+	Name         string                `json:"name"`     // name of the transfer
+	Revision     int                   `json:"revision"` // revision of the transfer after the update
+	Changes      []string              `json:"changes"`  // names of the changed fields
+	Previous     fileTransferPrevious  `json:"previous"`
+	PreviousFile *fileTransferPrevious `json:"previousFile,omitempty" metadata:",optional"` // previous location, only when it changed and the private details are readable
+	ChangedBy    string                `json:"changedBy"`
+	ChangedByMSP string                `json:"changedByMSP"`
+	TxID         string                `json:"txId"`
+	ChangedAt    time.Time             `json:"changedAt"` // transaction timestamp of the update
+}
+
+// fileTransferPrivateRevision holds the previous location of the file of a history entry
+type fileTransferPrivateRevision struct {
+	ObjectType string               `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name       string               `json:"name"`
+	Revision   int                  `json:"revision"`
+	Previous   fileTransferPrevious `json:"previous"`
+}
+
+// fileTransferPrevious are the values of the mutable fields of a transfer before an update.
+// Only the fields that changed are set. The description is always present, empty unless it
+// changed, as the contract metadata needs at least one required field. The address, location
+// and plaintext digest of the previous file are only set in the private revision
+type fileTransferPrevious struct {
+	Description    string              `json:"description"`
+	Authorization  string              `json:"authorization,omitempty" metadata:",optional"`
+	Recipients     []transferRecipient `json:"recipients,omitempty" metadata:",optional"`
+	CiphertextHash string              `json:"ciphertextHash,omitempty" metadata:",optional"`
+	CiphertextSize int64               `json:"ciphertextSize,omitempty" metadata:",optional"`
+	Address        string              `json:"address,omitempty" metadata:",optional"`
+	Location       *storageLocator     `json:"location,omitempty" metadata:",optional"`
+	PlaintextHash  string              `json:"plaintextHash,omitempty" metadata:",optional"`
+	PlaintextSize  int64               `json:"plaintextSize,omitempty" metadata:",optional"`
+}
+
+// getRevisionKey returns the key of the history entry of a revision of a transfer
+func getRevisionKey(stub shim.ChaincodeStubInterface, name string, revision int) (string, error) {
+	return stub.CreateCompositeKey(revisionIndex, []string{name, fmt.Sprintf("%010d", revision)})
+}
+
+// ===========================================================================================
+// UpdateFileTransfer - change the description, authorization, recipients or file location of a
+// transfer that has not been accessed yet. Only the fields given in the transient map under the
+// transfer_update key are changed. New recipients need the file key, as when the transfer is
+// created, and replace the previous recipients and their keys. A new file location replaces the
+// digests of the previous file by those given along with it, if any. Each update increments the
+// revision of the transfer and writes a history entry with the previous values
+// ===========================================================================================
+func (c *FileTransferContract) UpdateFileTransfer(ctx TransactionContextInterface) error {
+	fmt.Println("- start update transfer")
+
+	type transferUpdateTransientInput struct {
+		Name          string                    `json:"name"`
+		Description   string                    `json:"description"`
+		Authorization string                    `json:"authorization"`
+		Recipients    []recipientTransientInput `json:"recipients"`
+		Recipient     string                    `json:"recipient"`
+		RecipientMSP  string                    `json:"recipientMSP"`
+		EncryptionKey string                    `json:"encryptionKey"`
+		WrappedKey    *wrappedKey               `json:"wrappedKey"`
+		Address       string                    `json:"address"`
+		Location      *storageLocator           `json:"location"`
+		Ciphertext    *fileDigest               `json:"ciphertext"` // optional, hash and size of the encrypted file at the new location
+		Plaintext     *fileDigest               `json:"plaintext"`  // optional, hash and size of the decrypted file at the new location
+	}
+
+	stub := ctx.GetStub()
+
+	var updateInput transferUpdateTransientInput
+	err := getTransientInput(ctx, "transfer_update", &updateInput)
+	if err != nil {
+		return err
+	}
+
+	if len(updateInput.Name) == 0 {
+		return fmt.Errorf("name field must be a non-empty string")
+	}
+
+	transfer, collections, err := getCallerFileTransfer(ctx, updateInput.Name)
+	if err != nil {
+		return err
+	}
+
+	// only the originator may update a transfer, and only while none of its recipients has the file
+	caller := ctx.GetCaller()
+	if !caller.isOriginatorOf(transfer) {
+		return fmt.Errorf("Only the originator of the transfer may update it: %s", updateInput.Name)
+	}
+	if transfer.HasBeenAccessed || (transfer.Status != statusCreated && transfer.Status != statusDelivered) {
+		return fmt.Errorf("Transfer %s is %s and can only be updated before it is accessed", transfer.Name, transfer.Status)
+	}
+
+	// the keys and locations of a bundle are per file, send a new version of the bundle instead
+	if transfer.isBundle() && (len(updateInput.Recipients) != 0 || len(updateInput.Recipient) != 0 || len(updateInput.RecipientMSP) != 0 ||
+		len(updateInput.Address) != 0 || updateInput.Location != nil || updateInput.Ciphertext != nil || updateInput.Plaintext != nil) {
+		return fmt.Errorf("Transfer %s is a bundle, its recipients and files can only be changed by superseding it", transfer.Name)
+	}
+
+	details, err := getFileTransferPrivateDetails(stub, collections, transfer.Name)
+	if err != nil {
+		return err
+	}
+
+	changes := []string{}
+	var previous, previousFile fileTransferPrevious
+
+	if len(updateInput.Description) != 0 && updateInput.Description != transfer.Description {
+		changes = append(changes, "description")
+		previous.Description = transfer.Description
+		transfer.Description = updateInput.Description
+	}
+
+	if len(updateInput.Authorization) != 0 && updateInput.Authorization != transfer.Authorization {
+		changes = append(changes, "authorization")
+		previous.Authorization = transfer.Authorization
+		transfer.Authorization = updateInput.Authorization
+	}
+
+	if len(updateInput.Recipients) != 0 || len(updateInput.Recipient) != 0 || len(updateInput.RecipientMSP) != 0 {
+		recipientInputs, err := normalizeRecipientInputs(updateInput.Recipients, updateInput.Recipient, updateInput.RecipientMSP, updateInput.WrappedKey)
+		if err != nil {
+			return err
+		}
+		recipients, wrappedKeys, err := parseRecipients(stub, recipientInputs, updateInput.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		// the transfer stays in its collections, so its recipients must be members of them
+		for _, recipient := range recipients {
+			if _, ok := collections.resolve(recipient.MSPID); !ok {
+				return fmt.Errorf("Transfer %s is shared between %s, its recipients can only be moved to another organization by superseding it", transfer.Name, strings.Join(collections.organizations(), " and "))
+			}
+		}
+		changes = append(changes, "recipients")
+		previous.Recipients = transfer.Recipients
+		transfer.Recipients = recipients
+		transfer.Recipient = ""
+		transfer.RecipientMSP = ""
+		details.EncryptionKey = updateInput.EncryptionKey
+		details.WrappedKeys = wrappedKeys
+	} else if len(updateInput.EncryptionKey) != 0 || updateInput.WrappedKey != nil {
+		return fmt.Errorf("encryptionKey and wrappedKey fields may only be given along with the recipients")
+	}
+
+	if len(updateInput.Address) != 0 || updateInput.Location != nil {
+		location, address, err := parseTransferLocation(updateInput.Address, updateInput.Location)
+		if err != nil {
+			return err
+		}
+		if address != details.Address || *location != *details.Location {
+			// the digests of the previous file do not describe the new one
+			ciphertextHash, ciphertextSize, err := parseFileDigest("ciphertext", updateInput.Ciphertext)
+			if err != nil {
+				return err
+			}
+			plaintextHash, plaintextSize, err := parseFileDigest("plaintext", updateInput.Plaintext)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, "location")
+			previous.CiphertextHash = transfer.CiphertextHash
+			previous.CiphertextSize = transfer.CiphertextSize
+			previousFile.Address = details.Address
+			previousFile.Location = details.Location
+			previousFile.PlaintextHash = details.PlaintextHash
+			previousFile.PlaintextSize = details.PlaintextSize
+			transfer.CiphertextHash = ciphertextHash
+			transfer.CiphertextSize = ciphertextSize
+			details.Address = address
+			details.Location = location
+			details.PlaintextHash = plaintextHash
+			details.PlaintextSize = plaintextSize
+		}
+	}
+	if (updateInput.Ciphertext != nil || updateInput.Plaintext != nil) && previousFile.Location == nil {
+		return fmt.Errorf("ciphertext and plaintext fields may only be given along with a new address or location")
+	}
+
+	if len(changes) == 0 {
+		return fmt.Errorf("No changes to transfer %s", transfer.Name)
+	}
+
+	// a new authorization, or new recipients, must be covered by the authorization of the transfer
+	var authorizationRecord *authorization
+	if len(previous.Authorization) != 0 || len(previous.Recipients) != 0 {
+		authorizationRecord, err = checkAuthorization(stub, transfer.Authorization, transfer.OriginatorMSP, transfer.Recipients, len(previous.Authorization) != 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	transfer.Revision++
+
+	// ==== Write the history entry with the previous values ====
+	revision := &fileTransferRevision{
+		ObjectType:   "fileTransferRevision",
+		Name:         transfer.Name,
+		Revision:     transfer.Revision,
+		Changes:      changes,
+		Previous:     previous,
+		ChangedBy:    caller.ID,
+		ChangedByMSP: caller.MSPID,
+		TxID:         stub.GetTxID(),
+		ChangedAt:    txTime,
+	}
+	revisionKey, err := getRevisionKey(stub, transfer.Name, transfer.Revision)
+	if err != nil {
+		return err
+	}
+	revisionJSONasBytes, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+	err = collections.putTransfers(stub, revisionKey, revisionJSONasBytes)
+	if err != nil {
+		return err
+	}
+	if previousFile.Location != nil {
+		privateRevision := &fileTransferPrivateRevision{
+			ObjectType: "fileTransferPrivateRevision",
+			Name:       transfer.Name,
+			Revision:   transfer.Revision,
+			Previous:   previousFile,
+		}
+		privateRevisionJSONasBytes, err := json.Marshal(privateRevision)
+		if err != nil {
+			return err
+		}
+		err = collections.putPrivateDetails(stub, revisionKey, privateRevisionJSONasBytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ==== Move the transfer in the authorization~name index ====
+	if len(previous.Authorization) != 0 {
+		oldIndexKey, err := getAuthorizationNameIndexKey(stub, collections, previous.Authorization, transfer.Name)
+		if err != nil {
+			return err
+		}
+		err = collections.delTransfers(stub, oldIndexKey)
+		if err != nil {
+			return err
+		}
+		newIndexKey, err := getAuthorizationNameIndexKey(stub, collections, transfer.Authorization, transfer.Name)
+		if err != nil {
+			return err
+		}
+		err = collections.putTransfers(stub, newIndexKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+		err = countAuthorizedTransfer(stub, authorizationRecord)
+		if err != nil {
+			return err
+		}
+		err = uncountAuthorizedTransfer(stub, previous.Authorization)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ==== Deliver the keys of new recipients to their organizations ====
+	if len(previous.Recipients) != 0 && transfer.KeyDelivery == keyDeliveryImplicit {
+		err = deleteTransferKeys(stub, collections, transfer)
+		if err != nil {
+			return err
+		}
+		err = deliverTransferKeys(stub, transfer, &details)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ==== Save the updated transfer and private details ====
+	transferJSONasBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	err = collections.putTransfers(stub, transfer.Name, transferJSONasBytes) //rewrite the transfer
+	if err != nil {
+		return err
+	}
+	detailsJSONasBytes, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	err = collections.putPrivateDetails(stub, transfer.Name, detailsJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	err = emitTransferEvent(stub, eventTransferUpdated, transfer.Name, caller)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("- end update transfer (success)")
+	return nil
+}
+
+// ===========================================================================================
+// GetTransferHistory returns the history entries of a transfer in revision order. The previous
+// location of the file is included when it changed and the private details can be read
+// ===========================================================================================
+func (c *FileTransferContract) GetTransferHistory(ctx TransactionContextInterface, name string) ([]fileTransferRevision, error) {
+	stub := ctx.GetStub()
+
+	collections, err := getTransferCollections(stub, ctx.GetCaller(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collections.Transfers, revisionIndex, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	history := []fileTransferRevision{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var revision fileTransferRevision
+		err = json.Unmarshal(queryResponse.Value, &revision)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON of: %s", string(queryResponse.Value))
+		}
+
+		privateRevisionAsBytes, err := stub.GetPrivateData(collections.PrivateDetails, queryResponse.Key)
+		if err == nil && privateRevisionAsBytes != nil {
+			var privateRevision fileTransferPrivateRevision
+			err = json.Unmarshal(privateRevisionAsBytes, &privateRevision)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decode JSON of: %s", string(privateRevisionAsBytes))
+			}
+			revision.PreviousFile = &privateRevision.Previous
+		}
+		history = append(history, revision)
+	}
+
+	fmt.Printf("- getTransferHistory found %d revisions of %s\n", len(history), name)
+	return history, nil
+}